@@ -1,25 +1,44 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"github.com/ninehills/p2pfile/pkg/libtorrent"
-
-	log "github.com/sirupsen/logrus"
 )
 
 func newDownloadCmd() *cobra.Command {
 	var downloadCmd = &cobra.Command{
 		Use:   "download",
-		Short: "Download file from magnet uri.",
-		Long: `Download file from magnet uri. Usage:
+		Short: "Download file from magnet uri or torrent file.",
+		Long: `Download file from magnet uri, HTTP(S) torrent url or local torrent file. Usage:
+
+p2pfile download <MAGNET_URI|TORRENT_URL|TORRENT_FILE>...
+p2pfile download --manifest <MANIFEST_FILE>
+p2pfile download --extract <DIR> <MAGNET_URI|TORRENT_URL|TORRENT_FILE>
 
-p2pfile download <MAGNET_URI>`,
-		Args: cobra.MinimumNArgs(1),
+Each line of manifest is "<MAGNET_URI|TORRENT_URL|TORRENT_FILE> [DIR]", DIR is relative to --dir.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && viper.GetString("manifest") == "" {
+				return fmt.Errorf("requires at least 1 arg or --manifest")
+			}
+			return nil
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			// TODO: 写入这里才生效，需要改进
 			initLogger(viper.GetBool("debug"))
+			extractDir := viper.GetString("extract")
+
+			dataDir := viper.GetString("dir")
+			jobs, err := downloadJobs(args, dataDir, viper.GetString("manifest"))
+			if err != nil {
+				fatal("Failed to read manifest: ", err)
+			}
 
 			seedingMaxTime := 0
 			// 当开启 seeding 后，seeding-max-time 才会生效
@@ -27,19 +46,45 @@ p2pfile download <MAGNET_URI>`,
 				seedingMaxTime = viper.GetInt("seeding-max-time")
 			}
 			torrentServer := libtorrent.TorrentServer{
-				Target:             args[0],
-				DataDir:            viper.GetString("dir"),
+				DataDir:            dataDir,
 				IsServe:            false,
 				IsResume:           false,
 				MaxSeedingSeconds:  seedingMaxTime,
 				SeedingAutoStop:    viper.GetBool("seeding-auto-stop"),
 				SpeedLimitDownload: viper.GetFloat64("download-limit"),
 				SpeedLimitUpload:   viper.GetFloat64("upload-limit"),
+				Fetch: libtorrent.FetchOptions{
+					Timeout: time.Duration(viper.GetInt("fetch-timeout")) * time.Second,
+					MaxSize: viper.GetInt64("fetch-max-size") << 20,
+					CAFile:  viper.GetString("ca-file"),
+				},
+				Fsync:        viper.GetBool("fsync"),
+				Timeout:      time.Duration(viper.GetInt("timeout")) * time.Second,
+				StallTimeout: time.Duration(viper.GetInt("stall-timeout")) * time.Second,
+				Hooks: libtorrent.Hooks{
+					OnComplete: viper.GetString("on-complete"),
+					OnError:    viper.GetString("on-error"),
+				},
 			}
-			err := torrentServer.Run()
+			if extractDir != "" {
+				torrentServer.Extract = libtorrent.ExtractOptions{
+					Dir:    extractDir,
+					Delete: viper.GetBool("extract-delete"),
+				}
+			}
+			if len(jobs) == 1 {
+				torrentServer.Target = jobs[0].Target
+				torrentServer.DataDir = jobs[0].DataDir
+			} else {
+				torrentServer.Jobs = jobs
+			}
+			ctx, stop := signalContext()
+			defer stop()
+			res, err := torrentServer.RunContext(ctx)
 			if err != nil {
-				log.Fatal("Failed to run torrent server: ", err)
+				fatal("Failed to run torrent server: ", err)
 			}
+			log.Infof("Downloaded %d bytes from peers in %s", res.Downloaded(), res.Duration.Truncate(time.Millisecond))
 		},
 	}
 	downloadCmd.Flags().SortFlags = false
@@ -47,10 +92,57 @@ p2pfile download <MAGNET_URI>`,
 	downloadCmd.Flags().Int("seeding-max-time", 600, "Seeding after download finish max time in seconds. default: 600(10min)")
 	downloadCmd.Flags().Bool("seeding-auto-stop", true, "Stop seeding after all nodes download finish. default: true")
 	downloadCmd.Flags().String("dir", "", "Set download dir. (default: .)")
+	downloadCmd.Flags().String("manifest", "", "Download all targets listed in manifest file in one session.")
+	downloadCmd.Flags().Bool("fsync", false, "Fsync downloaded files before moving them into download dir.")
+	downloadCmd.Flags().String("extract", "", "Extract downloaded tar, tar.gz, tar.zst or zip archive into dir, only for single file torrent.")
+	downloadCmd.Flags().Bool("extract-delete", false, "Delete the archive after extracted.")
+	downloadCmd.Flags().String("on-complete", "", "Run shell command after download finished, see README for environment variables.")
+	downloadCmd.Flags().String("on-error", "", "Run shell command after download failed, see README for environment variables.")
+	downloadCmd.Flags().Int("timeout", 0, "Abort if download is not finished in seconds, see README for exit codes. (default: no timeout)")
+	downloadCmd.Flags().Int("stall-timeout", 0, "Abort if no metadata or data is received in seconds, see README for exit codes. (default: no timeout)")
+	downloadCmd.Flags().Int("fetch-timeout", 30, "Timeout in seconds when fetching torrent file from HTTP(S) url. default: 30")
+	downloadCmd.Flags().Int64("fetch-max-size", 10, "Max size of torrent file, MiB. default: 10")
+	downloadCmd.Flags().String("ca-file", "", "PEM encoded CA bundle to verify HTTPS torrent url. (default: system CA)")
 
 	viper.BindPFlag("dir", downloadCmd.Flags().Lookup("dir"))
+	viper.BindPFlag("manifest", downloadCmd.Flags().Lookup("manifest"))
 	viper.BindPFlag("seeding", downloadCmd.Flags().Lookup("seeding"))
 	viper.BindPFlag("seeding-max-time", downloadCmd.Flags().Lookup("seeding-max-time"))
 	viper.BindPFlag("seeding-auto-stop", downloadCmd.Flags().Lookup("seeding-auto-stop"))
+	viper.BindPFlag("fsync", downloadCmd.Flags().Lookup("fsync"))
+	viper.BindPFlag("extract", downloadCmd.Flags().Lookup("extract"))
+	viper.BindPFlag("extract-delete", downloadCmd.Flags().Lookup("extract-delete"))
+	viper.BindPFlag("on-complete", downloadCmd.Flags().Lookup("on-complete"))
+	viper.BindPFlag("on-error", downloadCmd.Flags().Lookup("on-error"))
+	viper.BindPFlag("timeout", downloadCmd.Flags().Lookup("timeout"))
+	viper.BindPFlag("stall-timeout", downloadCmd.Flags().Lookup("stall-timeout"))
+	viper.BindPFlag("fetch-timeout", downloadCmd.Flags().Lookup("fetch-timeout"))
+	viper.BindPFlag("fetch-max-size", downloadCmd.Flags().Lookup("fetch-max-size"))
+	viper.BindPFlag("ca-file", downloadCmd.Flags().Lookup("ca-file"))
 	return downloadCmd
 }
+
+// downloadJobs 合并命令行参数和 manifest 文件中的下载任务
+func downloadJobs(args []string, dataDir string, manifest string) ([]libtorrent.DownloadJob, error) {
+	jobs := make([]libtorrent.DownloadJob, 0, len(args))
+	for _, arg := range args {
+		jobs = append(jobs, libtorrent.DownloadJob{Target: arg, DataDir: dataDir})
+	}
+	if manifest == "" {
+		return jobs, nil
+	}
+	f, err := os.Open(manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifestJobs, err := libtorrent.ParseManifest(f, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	jobs = append(jobs, manifestJobs...)
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no target in manifest: %s", manifest)
+	}
+	return jobs, nil
+}
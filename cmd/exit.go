@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/ninehills/p2pfile/pkg/libtorrent"
+	log "github.com/sirupsen/logrus"
+)
+
+// 退出码，见 README
+const (
+	exitFailed             = 1
+	exitUsage              = 2
+	exitTimeout            = 3
+	exitStalled            = 4
+	exitMetadataTimeout    = 5
+	exitTrackerUnreachable = 6
+	exitDiskFull           = 7
+	exitVerification       = 8
+	exitInterrupted        = 130
+)
+
+// exitCodes 按顺序匹配，ErrTrackerUnreachable 优先于超时
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{libtorrent.ErrInvalidTarget, exitUsage},
+	{libtorrent.ErrTrackerUnreachable, exitTrackerUnreachable},
+	{libtorrent.ErrTimeout, exitTimeout},
+	{libtorrent.ErrStalled, exitStalled},
+	{libtorrent.ErrMetadataTimeout, exitMetadataTimeout},
+	{libtorrent.ErrDiskFull, exitDiskFull},
+	{libtorrent.ErrVerification, exitVerification},
+	{libtorrent.ErrInterrupted, exitInterrupted},
+}
+
+func exitCode(err error) int {
+	for _, c := range exitCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return exitFailed
+}
+
+// fatal 输出错误并以 err 对应的退出码退出
+func fatal(msg string, err error) {
+	log.Error(msg, err)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ninehills/p2pfile/pkg/libtracker"
+	magnetlib "github.com/ninehills/p2pfile/pkg/magnet"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newReportCmd() *cobra.Command {
+	var reportCmd = &cobra.Command{
+		Use:   "report",
+		Short: "Show download report from the tracker of serve.",
+		Long: `Show which peers have started and completed downloading, from the tracker of serve. Usage:
+
+p2pfile report <MAGNET_URI>
+p2pfile report <TRACKER_URL>
+
+TRACKER_URL is like http://<ip>:<port>/<room>/announce, and reports all torrents of the room.
+If serve is started with --secret, use the report url with token printed by serve.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			initLogger(viper.GetBool("debug"))
+			url, err := reportURL(args[0])
+			if err != nil {
+				fatal("Invalid argument: ", err)
+			}
+			report, err := libtracker.FetchReport(url)
+			if err != nil {
+				fatal("Failed to get report: ", err)
+			}
+			if viper.GetBool("json") {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					fatal("Failed to write report: ", err)
+				}
+				return
+			}
+			printReport(report)
+		},
+	}
+	reportCmd.Flags().Bool("json", false, "Print report in JSON.")
+	viper.BindPFlag("json", reportCmd.Flags().Lookup("json"))
+	return reportCmd
+}
+
+// reportURL 返回 tracker 的 report 接口地址，参数为 magnet 时只查询该 torrent。tracker url 中的 token 等参数会被保留
+func reportURL(arg string) (string, error) {
+	infoHash := ""
+	if strings.HasPrefix(arg, "magnet:") {
+		m, err := magnetlib.New(arg)
+		if err != nil {
+			return "", err
+		}
+		if len(m.Trackers) == 0 || len(m.Trackers[0]) == 0 {
+			return "", fmt.Errorf("no tracker in magnet uri")
+		}
+		infoHash = hex.EncodeToString(m.InfoHash[:])
+		arg = m.Trackers[0][0]
+	}
+	u, err := url.Parse(arg)
+	if err != nil {
+		return "", err
+	}
+	p := strings.TrimSuffix(u.Path, "/")
+	switch {
+	case strings.HasSuffix(p, "/announce"):
+		p = strings.TrimSuffix(p, "/announce") + "/report"
+	case !strings.HasSuffix(p, "/report"):
+		p += "/report"
+	}
+	u.Path = p
+	if infoHash != "" {
+		q := u.Query()
+		q.Set("info_hash", infoHash)
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+func printReport(report *libtracker.Report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, t := range report.Torrents {
+		fmt.Fprintf(w, "Torrent %s: seeders %d, leechers %d, completed %d\n", t.InfoHash, t.Seeders, t.Leechers, t.Completed)
+		fmt.Fprintln(w, "IP\tPORT\tPEER_ID\tSTARTED\tCOMPLETED\tDOWNLOADED\tUPLOADED\tLEFT\tLAST_EVENT")
+		for _, p := range t.Peers {
+			peerID, _ := hex.DecodeString(p.PeerID)
+			fmt.Fprintf(w, "%s\t%d\t%q\t%s\t%s\t%d\t%d\t%d\t%s\n",
+				p.IP, p.Port, peerID, p.Started.Format(time.RFC3339), formatTime(p.Completed), p.Downloaded, p.Uploaded, p.Left, p.Event)
+		}
+		fmt.Fprintln(w)
+		if len(t.Hosts) == 0 {
+			continue
+		}
+		fmt.Fprintln(w, "HOST\tSTATUS\tSTARTED\tCOMPLETED")
+		for _, h := range t.Hosts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", h.Host, h.Status, formatTime(h.Started), formatTime(h.Completed))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
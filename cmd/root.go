@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -18,7 +21,7 @@ var rootCmd = &cobra.Command{
 	Long: `Simple P2P file distribution CLI. For example:
 
 p2pfile serve <FILE_PATH>
-p2pfile download <MAGNET_URI>`,
+p2pfile download <MAGNET_URI|TORRENT_URL|TORRENT_FILE>`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
@@ -29,7 +32,8 @@ p2pfile download <MAGNET_URI>`,
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		// 参数错误
+		os.Exit(exitUsage)
 	}
 }
 
@@ -50,6 +54,7 @@ func init() {
 
 	rootCmd.AddCommand(newServeCmd())
 	rootCmd.AddCommand(newDownloadCmd())
+	rootCmd.AddCommand(newReportCmd())
 	rootCmd.AddCommand(newVersionCmd())
 }
 
@@ -90,3 +95,20 @@ func initLogger(debug bool) {
 		log.SetLevel(log.InfoLevel)
 	}
 }
+
+// signalContext 返回收到 SIGINT 或 SIGTERM 时取消的 context。收到信号后恢复默认处理，再次收到时直接退出
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-ch:
+			log.Infof("Received %s, stopping", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx, cancel
+}
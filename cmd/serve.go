@@ -1,17 +1,25 @@
 package cmd
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ninehills/p2pfile/pkg/libtorrent"
 	"github.com/ninehills/p2pfile/pkg/libtracker"
+	magnetlib "github.com/ninehills/p2pfile/pkg/magnet"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 // Tracker Room Name
-const trackerRoom = "1"
+const defaultTrackerRoom = "1"
 
 func newServeCmd() *cobra.Command {
 	var serveCmd = &cobra.Command{
@@ -19,7 +27,11 @@ func newServeCmd() *cobra.Command {
 		Short: "creates and seeds a torrent from file paths.",
 		Long: `Creates and seeds a torrent from file paths. Usage:
 
-p2pfile serve <FILE_PATH>`,
+p2pfile serve <FILE_PATH>
+p2pfile serve --expect <N> <FILE_PATH>
+p2pfile serve --hosts-file <HOSTS_FILE> <FILE_PATH>
+
+Each line of hosts file is a hostname or IP expected to download the file, lines starting with "#" are ignored.`,
 		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			// TODO: 写入这里才生效，需要改进
@@ -30,32 +42,97 @@ p2pfile serve <FILE_PATH>`,
 			// 1. Start tracker
 			trackerIP, err := libtorrent.GetPublicIP(viper.GetString("tracker-ip"))
 			if err != nil {
-				log.Fatal("Failed to get public ip", err)
+				fatal("Failed to get public ip: ", err)
 			}
 			trackerPort := viper.GetInt("tracker-port")
 			if trackerPort == 0 {
 				// TODO: 使用随机端口会导致 serve 服务重启后原 magnet uri 失效
 				trackerPort, err = libtorrent.GetAvailablePort(viper.GetString("tracker-port-range"))
 				if err != nil {
-					log.Fatalf("Couldn't get available port: %v", err)
+					fatal("Couldn't get available port: ", err)
 				} else {
 					log.Infof("Founded available port: %v", trackerPort)
 				}
 			}
-			trackerURL := fmt.Sprintf("http://%s:%d/%s/announce", trackerIP, trackerPort, trackerRoom)
+			room := viper.GetString("room")
+			// IPv6 地址需要加上方括号
+			trackerURL := fmt.Sprintf("http://%s/%s/announce", net.JoinHostPort(trackerIP.String(), strconv.Itoa(trackerPort)), room)
 			log.Infof("Start tracker: %s (debug: %v)", trackerURL, debug)
+			if trackerIP.To4() == nil {
+				// rain 只支持 IPv4，无法 announce 到 IPv6 地址的 tracker，download 会因为 tracker 无法访问而失败
+				log.Warnf("Tracker ip %s is IPv6, but the built-in BitTorrent client only supports IPv4: "+
+					"p2pfile download can't use this tracker and files can't be transferred over IPv6, only other BEP 7 clients can use it", trackerIP)
+			}
+			secret := viper.GetString("secret")
+			if secret != "" {
+				libtracker.SetRoomSecret(room, secret)
+				log.Infof("Tracker requires token, report url: %s?token=%s",
+					strings.TrimSuffix(trackerURL, "/announce")+"/report", libtracker.Token(secret, room, ""))
+			}
+			hosts, err := expectedHosts(viper.GetStringSlice("hosts"), viper.GetString("hosts-file"))
+			if err != nil {
+				fatal("Failed to read hosts file: ", err)
+			}
+			if len(hosts) > 0 {
+				libtracker.SetRoster(room, hosts)
+			}
+			if viper.GetBool("allowlist") {
+				libtracker.EnableAllowlist()
+			}
+			libtracker.SetAdminToken(viper.GetString("admin-token"))
+			if err := setIPPolicies(); err != nil {
+				fatal("Invalid tracker ip policy: ", err)
+			}
+			if err := setTopology(); err != nil {
+				fatal("Invalid tracker zones: ", err)
+			}
+			libtracker.SetIntervalPolicy(libtracker.IntervalPolicy{
+				Min:             time.Duration(viper.GetInt("min-interval")) * time.Second,
+				Max:             time.Duration(viper.GetInt("max-interval")) * time.Second,
+				MaxAnnounceRate: viper.GetFloat64("max-announce-rate"),
+			})
+			libtracker.SetExpiryPolicy(libtracker.ExpiryPolicy{
+				Factor:  viper.GetFloat64("expiry-factor"),
+				Grace:   time.Duration(viper.GetInt("expiry-grace")) * time.Second,
+				History: time.Duration(viper.GetInt("history-ttl")) * time.Second,
+			})
+			libtracker.SetPeerSelection(libtracker.PeerSelection{
+				SeederRatio: viper.GetFloat64("seeder-ratio"),
+				MaxNumWant:  viper.GetUint("max-numwant"),
+			})
+			libtracker.SetLimits(libtracker.Limits{
+				RequestRate:       viper.GetFloat64("rate-limit"),
+				RequestBurst:      viper.GetInt64("rate-burst"),
+				MaxSwarmsPerRoom:  viper.GetInt("max-swarms-per-room"),
+				MaxPeersPerRoom:   viper.GetInt("max-peers-per-room"),
+				MaxSwarms:         viper.GetInt("max-swarms"),
+				MaxPeers:          viper.GetInt("max-peers"),
+				AllowFastAnnounce: viper.GetBool("allow-fast-announce"),
+			})
 			go libtracker.RunTrackerServer(fmt.Sprintf(":%d", trackerPort), debug)
 
 			// 2. make torrent
 			torrentFile := file + ".torrent"
 			files := []string{file}
-			trackers := []string{trackerURL}
+			// 开启认证时 tracker url 带有 info hash 的 token，只有持有 magnet 或 torrent 的节点可以 announce
+			trackersOf := func(infoHash string) []string {
+				if secret == "" {
+					return []string{trackerURL}
+				}
+				ih, _ := hex.DecodeString(infoHash)
+				return []string{trackerURL + "?token=" + libtracker.Token(secret, room, string(ih))}
+			}
 			log.Infof("Make torrent %s to %s", file, torrentFile)
-			magnet, err := libtorrent.CreateTorrent(files, torrentFile, "", "", false, 0, "", trackers, []string{})
+			magnet, err := libtorrent.CreateTorrentWithTrackers(files, torrentFile, "", "", false, 0, "", trackersOf, []string{})
 			if err != nil {
-				log.Fatal("Failed to create torrent: ", err)
+				fatal("Failed to create torrent: ", err)
 			}
 			log.Infof("Magnet: %s", magnet)
+			m, err := magnetlib.New(magnet)
+			if err != nil {
+				fatal("Failed to parse magnet: ", err)
+			}
+			libtracker.AllowTorrent(room, string(m.InfoHash[:]))
 			// 3. Start torrent uploader
 			torrentServer := libtorrent.TorrentServer{
 				Target:             torrentFile,
@@ -67,19 +144,237 @@ p2pfile serve <FILE_PATH>`,
 				SpeedLimitDownload: viper.GetFloat64("download-limit"),
 				SpeedLimitUpload:   viper.GetFloat64("upload-limit"),
 			}
-			err = torrentServer.Run()
+			ctx, stop := signalContext()
+			defer stop()
+			if expect := viper.GetInt("expect"); expect > 0 {
+				go waitExpected(ctx, stop, room, string(m.InfoHash[:]), expect)
+			}
+			if len(hosts) > 0 {
+				go watchHosts(ctx, room, string(m.InfoHash[:]))
+			}
+			_, err = torrentServer.RunContext(ctx)
+			if len(hosts) > 0 {
+				logHosts(room, string(m.InfoHash[:]))
+			}
 			if err != nil {
-				log.Fatal("Failed to run torrent server: ", err)
+				fatal("Failed to run torrent server: ", err)
 			}
 		},
 	}
 	serveCmd.Flags().SortFlags = false
-	serveCmd.Flags().String("tracker-ip", "", "Set tracker ip. (default: default route ip)")
+	serveCmd.Flags().String("tracker-ip", "", "Set tracker ip, IPv4 or IPv6. (default: default route ip, IPv4 preferred)")
 	serveCmd.Flags().Int("tracker-port", 0, "Set tracker port. (default: random port in port-range,  See --port-range)")
 	serveCmd.Flags().String("tracker-port-range", "42070-42099", "Set tracker random port range. (default: 42070-42099)")
+	serveCmd.Flags().String("room", defaultTrackerRoom, "Set tracker room, torrents in different rooms don't share peers.")
+	serveCmd.Flags().String("secret", "", "Only accept announces with token signed by secret, the token is included in magnet uri.")
+	serveCmd.Flags().Bool("allowlist", false, "Only accept announces of the served torrent and torrents registered by admin API.")
+	serveCmd.Flags().String("admin-token", "", "Enable tracker admin API with bearer token. (default: disabled)")
+	serveCmd.Flags().StringSlice("trust-ip-param", nil, "Register the ip param of announces from these IPs or CIDRs as peer address, e.g. peers behind NAT.")
+	serveCmd.Flags().StringSlice("trusted-proxies", nil, "Read peer address from X-Forwarded-For header of announces from these proxy IPs or CIDRs.")
+	serveCmd.Flags().StringArray("zone", nil, "Assign peers in CIDR to zone, format: ZONE=CIDR, can be repeated. Peers in the same zone are preferred.")
+	serveCmd.Flags().Float64("local-peer-ratio", 0, "Max ratio of peers in the same zone returned by tracker. (default: 0.8)")
+	serveCmd.Flags().Float64("seeder-ratio", 0, "Ratio of seeders in peers returned to leechers by tracker. (default: 0.5)")
+	serveCmd.Flags().Uint("max-numwant", 0, "Max number of peers returned by tracker for each announce. (default: 100)")
+	serveCmd.Flags().Int("min-interval", 0, "Min announce interval returned by tracker, seconds. (default: 15)")
+	serveCmd.Flags().Int("max-interval", 0, "Max announce interval returned by tracker, seconds. (default: 1800)")
+	serveCmd.Flags().Float64("max-announce-rate", 0, "Extend announce interval when tracker receives more announces per second. (default: 500)")
+	serveCmd.Flags().Float64("expiry-factor", 0, "Remove peers not announced for N announce intervals plus expiry grace. (default: 2)")
+	serveCmd.Flags().Int("expiry-grace", 0, "Extra seconds before peers expire. (default: 30)")
+	serveCmd.Flags().Int("history-ttl", 0, "Remove download records of peers not announced in seconds. (default: 86400)")
+	serveCmd.Flags().Float64("rate-limit", 0, "Max tracker requests per second of each client IP, -1 for unlimited. (default: 20)")
+	serveCmd.Flags().Int64("rate-burst", 0, "Max burst tracker requests of each client IP. (default: 2 * rate-limit)")
+	serveCmd.Flags().Int("max-swarms-per-room", 0, "Max torrents in each tracker room, -1 for unlimited. (default: 10000)")
+	serveCmd.Flags().Int("max-peers-per-room", 0, "Max peers in each tracker room, -1 for unlimited. (default: 100000)")
+	serveCmd.Flags().Int("max-swarms", 0, "Max torrents in all tracker rooms, -1 for unlimited. (default: 100000)")
+	serveCmd.Flags().Int("max-peers", 0, "Max peers in all tracker rooms, -1 for unlimited. (default: 1000000)")
+	serveCmd.Flags().Bool("allow-fast-announce", false, "Allow clients to announce before the min interval returned by tracker.")
+	serveCmd.Flags().StringSlice("hosts", nil, "Hostnames or IPs expected to download the file, report their status continuously.")
+	serveCmd.Flags().String("hosts-file", "", "Read expected hosts from file, one per line.")
+	serveCmd.Flags().Int("expect", 0, "Stop seeding after tracker recorded N completed downloads and no leecher remains. (default: seeding forever)")
 
 	viper.BindPFlag("tracker-ip", serveCmd.Flags().Lookup("tracker-ip"))
 	viper.BindPFlag("tracker-port", serveCmd.Flags().Lookup("tracker-port"))
 	viper.BindPFlag("tracker-port-range", serveCmd.Flags().Lookup("tracker-port-range"))
+	viper.BindPFlag("room", serveCmd.Flags().Lookup("room"))
+	viper.BindPFlag("secret", serveCmd.Flags().Lookup("secret"))
+	viper.BindPFlag("allowlist", serveCmd.Flags().Lookup("allowlist"))
+	viper.BindPFlag("admin-token", serveCmd.Flags().Lookup("admin-token"))
+	viper.BindPFlag("trust-ip-param", serveCmd.Flags().Lookup("trust-ip-param"))
+	viper.BindPFlag("trusted-proxies", serveCmd.Flags().Lookup("trusted-proxies"))
+	viper.BindPFlag("zone", serveCmd.Flags().Lookup("zone"))
+	viper.BindPFlag("local-peer-ratio", serveCmd.Flags().Lookup("local-peer-ratio"))
+	viper.BindPFlag("seeder-ratio", serveCmd.Flags().Lookup("seeder-ratio"))
+	viper.BindPFlag("max-numwant", serveCmd.Flags().Lookup("max-numwant"))
+	viper.BindPFlag("min-interval", serveCmd.Flags().Lookup("min-interval"))
+	viper.BindPFlag("max-interval", serveCmd.Flags().Lookup("max-interval"))
+	viper.BindPFlag("max-announce-rate", serveCmd.Flags().Lookup("max-announce-rate"))
+	viper.BindPFlag("expiry-factor", serveCmd.Flags().Lookup("expiry-factor"))
+	viper.BindPFlag("expiry-grace", serveCmd.Flags().Lookup("expiry-grace"))
+	viper.BindPFlag("history-ttl", serveCmd.Flags().Lookup("history-ttl"))
+	viper.BindPFlag("rate-limit", serveCmd.Flags().Lookup("rate-limit"))
+	viper.BindPFlag("rate-burst", serveCmd.Flags().Lookup("rate-burst"))
+	viper.BindPFlag("max-swarms-per-room", serveCmd.Flags().Lookup("max-swarms-per-room"))
+	viper.BindPFlag("max-peers-per-room", serveCmd.Flags().Lookup("max-peers-per-room"))
+	viper.BindPFlag("max-swarms", serveCmd.Flags().Lookup("max-swarms"))
+	viper.BindPFlag("max-peers", serveCmd.Flags().Lookup("max-peers"))
+	viper.BindPFlag("allow-fast-announce", serveCmd.Flags().Lookup("allow-fast-announce"))
+	viper.BindPFlag("hosts", serveCmd.Flags().Lookup("hosts"))
+	viper.BindPFlag("hosts-file", serveCmd.Flags().Lookup("hosts-file"))
+	viper.BindPFlag("expect", serveCmd.Flags().Lookup("expect"))
 	return serveCmd
 }
+
+// roomIPPolicy 是配置文件中 room-ip-policies 的一个 room
+type roomIPPolicy struct {
+	TrustIPParam   []string `mapstructure:"trust-ip-param"`
+	TrustedProxies []string `mapstructure:"trusted-proxies"`
+}
+
+func (p roomIPPolicy) parse() (policy libtracker.IPPolicy, err error) {
+	if policy.TrustIPParam, err = libtracker.ParseIPNets(p.TrustIPParam); err != nil {
+		return
+	}
+	policy.TrustedProxies, err = libtracker.ParseIPNets(p.TrustedProxies)
+	return
+}
+
+// setIPPolicies 设置 tracker 记录 peer 地址的策略，配置文件中 room-ip-policies 的 room 覆盖默认策略
+func setIPPolicies() error {
+	policy, err := roomIPPolicy{
+		TrustIPParam:   viper.GetStringSlice("trust-ip-param"),
+		TrustedProxies: viper.GetStringSlice("trusted-proxies"),
+	}.parse()
+	if err != nil {
+		return err
+	}
+	libtracker.SetIPPolicy(policy)
+	var rooms map[string]roomIPPolicy
+	if err := viper.UnmarshalKey("room-ip-policies", &rooms); err != nil {
+		return err
+	}
+	for room, p := range rooms {
+		policy, err := p.parse()
+		if err != nil {
+			return fmt.Errorf("room %s: %w", room, err)
+		}
+		libtracker.SetRoomIPPolicy(room, policy)
+	}
+	return nil
+}
+
+// setTopology 设置 tracker 的 zone，合并 --zone 参数和配置文件中的 zones
+func setTopology() error {
+	zones := make(map[string][]string)
+	if err := viper.UnmarshalKey("zones", &zones); err != nil {
+		return err
+	}
+	for _, z := range viper.GetStringSlice("zone") {
+		i := strings.Index(z, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid zone %q, format: ZONE=CIDR", z)
+		}
+		zones[z[:i]] = append(zones[z[:i]], z[i+1:])
+	}
+	topology := libtracker.Topology{
+		Zones:      make(map[string][]*net.IPNet),
+		LocalRatio: viper.GetFloat64("local-peer-ratio"),
+	}
+	for zone, cidrs := range zones {
+		nets, err := libtracker.ParseIPNets(cidrs)
+		if err != nil {
+			return fmt.Errorf("zone %s: %w", zone, err)
+		}
+		topology.Zones[zone] = nets
+	}
+	libtracker.SetTopology(topology)
+	return nil
+}
+
+// waitExpected 在 tracker 记录了 expect 个完成的下载且没有 leecher 时调用 stop 停止做种。
+// 只统计下载过程中发送了 completed 事件的节点，启动时已经完成下载的节点不计入。
+// 完成下载的节点在这里累计，不依赖 tracker 保留下载记录的时间
+func waitExpected(ctx context.Context, stop context.CancelFunc, room, infoHash string, expect int) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	done := make(map[string]bool)
+	last := -1
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, addr := range libtracker.CompletedPeers(room, infoHash) {
+			done[addr] = true
+		}
+		completed := len(done)
+		_, leechers := libtracker.GetStats(room, infoHash)
+		if completed != last {
+			log.Infof("Completed downloads: %d/%d, leechers: %d", completed, expect, leechers)
+			last = completed
+		}
+		if completed >= expect && leechers == 0 {
+			log.Infof("All %d expected downloads completed, stop seeding.", expect)
+			stop()
+			return
+		}
+	}
+}
+
+// expectedHosts 合并命令行参数和 hosts 文件中的预期节点
+func expectedHosts(hosts []string, file string) ([]string, error) {
+	if file == "" {
+		return hosts, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	return hosts, nil
+}
+
+// watchHosts 在预期节点的状态变化时输出统计
+func watchHosts(ctx context.Context, room, infoHash string) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		count := make(map[string]int)
+		hosts := libtracker.GetHosts(room, infoHash)
+		for _, h := range hosts {
+			count[h.Status]++
+		}
+		summary := fmt.Sprintf("Expected hosts: %d completed, %d started, %d missing of %d",
+			count[libtracker.HostCompleted], count[libtracker.HostStarted], count[libtracker.HostMissing], len(hosts))
+		if summary != last {
+			log.Info(summary)
+			last = summary
+		}
+	}
+}
+
+// logHosts 输出没有完成下载的预期节点
+func logHosts(room, infoHash string) {
+	incomplete := 0
+	for _, h := range libtracker.GetHosts(room, infoHash) {
+		if h.Status != libtracker.HostCompleted {
+			incomplete++
+			log.Warnf("Host %s did not complete download, status: %s", h.Host, h.Status)
+		}
+	}
+	if incomplete == 0 {
+		log.Infof("All expected hosts completed download")
+	}
+}
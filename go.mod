@@ -6,6 +6,8 @@ require (
 	github.com/cenkalti/rain v1.8.6
 	github.com/gin-gonic/gin v1.7.7
 	github.com/jackpal/bencode-go v1.0.0
+	github.com/juju/ratelimit v1.0.1
+	github.com/klauspost/compress v1.15.15
 	github.com/multiformats/go-multihash v0.1.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.3.0
@@ -34,7 +36,6 @@ require (
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
-	github.com/juju/ratelimit v1.0.1 // indirect
 	github.com/julienschmidt/httprouter v1.3.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/leodido/go-urn v1.2.0 // indirect
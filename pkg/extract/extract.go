@@ -0,0 +1,293 @@
+// Package extract unpacks tar, tar.gz, tar.zst and zip archives.
+//
+// Entries with absolute paths or ".." elements are rejected, symbolic links must point inside
+// the destination directory, and entries are never written through an existing symbolic link.
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format of archive, detected from the leading bytes.
+type Format int
+
+const (
+	Unknown Format = iota
+	Tar
+	TarGzip
+	TarZstd
+	Zip
+)
+
+var ErrUnknownFormat = errors.New("unknown archive format")
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+	tarMagic  = []byte("ustar")
+)
+
+func (f Format) String() string {
+	switch f {
+	case Tar:
+		return "tar"
+	case TarGzip:
+		return "tar.gz"
+	case TarZstd:
+		return "tar.zst"
+	case Zip:
+		return "zip"
+	default:
+		return "unknown"
+	}
+}
+
+// Detect returns the format of archive from its leading bytes.
+func Detect(header []byte) Format {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return TarGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return TarZstd
+	case bytes.HasPrefix(header, zipMagic):
+		return Zip
+	case len(header) >= 262 && bytes.Equal(header[257:262], tarMagic):
+		return Tar
+	default:
+		return Unknown
+	}
+}
+
+// File extracts archive file name into dir.
+func File(name, dir string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, 262)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	format := Detect(header[:n])
+	if format == Zip {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, fi.Size())
+		if err != nil {
+			return err
+		}
+		return extractZip(zr, dir)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return reader(f, format, dir)
+}
+
+func reader(r io.Reader, format Format, dir string) error {
+	switch format {
+	case Tar:
+		return extractTar(tar.NewReader(r), dir)
+	case TarGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return extractTar(tar.NewReader(zr), dir)
+	case TarZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return extractTar(tar.NewReader(zr), dir)
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+func extractTar(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = makeDir(dir, hdr.Name)
+		case tar.TypeReg:
+			err = writeFile(dir, hdr.Name, tr, mode)
+		case tar.TypeSymlink:
+			err = makeSymlink(dir, hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = makeHardlink(dir, hdr.Name, hdr.Linkname)
+		default:
+			// 忽略设备文件、FIFO 及 PAX 扩展头等
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(zr *zip.Reader, dir string) error {
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err := makeDir(dir, f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		if mode&os.ModeSymlink != 0 {
+			var target []byte
+			target, err = io.ReadAll(io.LimitReader(rc, 4096))
+			if err == nil {
+				err = makeSymlink(dir, f.Name, string(target))
+			}
+		} else {
+			err = writeFile(dir, f.Name, rc, mode.Perm())
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// safePath 返回 entry 在 dir 下的路径，拒绝绝对路径、包含 ".." 的路径及经过符号链接的路径
+func safePath(dir, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || strings.HasPrefix(name, string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file name: %q", name)
+	}
+	// 和 metainfo.NewInfo 相同，".." 不允许出现在路径中
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		if strings.TrimSpace(part) == ".." {
+			return "", fmt.Errorf("invalid file name: %q", name)
+		}
+	}
+	p := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, p)
+	if err != nil || rel == "." {
+		return "", fmt.Errorf("invalid file name: %q", name)
+	}
+	// 上级目录中不能有符号链接，避免通过符号链接写入 dir 之外
+	parent := dir
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		fi, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("invalid file name: %q, parent %q is a symbolic link", name, parent)
+		}
+	}
+	return p, nil
+}
+
+func makeDir(dir, name string) error {
+	if filepath.Clean(filepath.FromSlash(name)) == "." {
+		// tar 中常见的 "./" 条目
+		return nil
+	}
+	p, err := safePath(dir, name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, 0o755)
+}
+
+func writeFile(dir, name string, r io.Reader, mode os.FileMode) error {
+	p, err := safePath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// 删除已存在的文件，避免写入已存在的符号链接指向的文件
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func makeSymlink(dir, name, target string) error {
+	p, err := safePath(dir, name)
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("invalid symbolic link %q: absolute target %q", name, target)
+	}
+	resolved := filepath.Join(filepath.Dir(p), target)
+	if rel, err := filepath.Rel(dir, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid symbolic link %q: target %q is outside of %s", name, target, dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, p)
+}
+
+func makeHardlink(dir, name, target string) error {
+	p, err := safePath(dir, name)
+	if err != nil {
+		return err
+	}
+	src, err := safePath(dir, target)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(src, p)
+}
@@ -0,0 +1,107 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func makeTar(t *testing.T, entries []entry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0o644, Size: int64(len(e.body)), Linkname: e.linkname}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		assert.NoError(t, tw.WriteHeader(hdr))
+		if e.typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(e.body))
+			assert.NoError(t, err)
+		}
+	}
+	assert.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// writeArchive 将压缩文件写入临时目录并返回路径
+func writeArchive(t *testing.T, b []byte) string {
+	name := filepath.Join(t.TempDir(), "archive")
+	assert.NoError(t, os.WriteFile(name, b, 0o600))
+	return name
+}
+
+func TestFile(t *testing.T) {
+	archive := makeTar(t, []entry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "bin/", typeflag: tar.TypeDir},
+		{name: "bin/app", typeflag: tar.TypeReg, body: "app"},
+		{name: "current", typeflag: tar.TypeSymlink, linkname: "bin/app"},
+	})
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(archive)
+	zw.Close()
+	var zst bytes.Buffer
+	enc, _ := zstd.NewWriter(&zst)
+	enc.Write(archive)
+	enc.Close()
+
+	for _, b := range [][]byte{archive, gz.Bytes(), zst.Bytes()} {
+		dir := t.TempDir()
+		assert.NoError(t, File(writeArchive(t, b), dir))
+		content, err := os.ReadFile(filepath.Join(dir, "current"))
+		assert.NoError(t, err)
+		assert.Equal(t, "app", string(content))
+	}
+}
+
+func TestPathTraversal(t *testing.T) {
+	cases := [][]entry{
+		{{name: "../evil", typeflag: tar.TypeReg, body: "x"}},
+		{{name: "/etc/evil", typeflag: tar.TypeReg, body: "x"}},
+		{{name: "link", typeflag: tar.TypeSymlink, linkname: "../.."}},
+		{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+		{
+			{name: "sub/", typeflag: tar.TypeDir},
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "sub"},
+			{name: "link/evil", typeflag: tar.TypeReg, body: "x"},
+		},
+		{{name: "hard", typeflag: tar.TypeLink, linkname: "../../etc/passwd"}},
+	}
+	for _, entries := range cases {
+		dir := t.TempDir()
+		assert.Error(t, File(writeArchive(t, makeTar(t, entries)), filepath.Join(dir, "out")), entries[len(entries)-1].name)
+	}
+}
+
+func TestZip(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.zip")
+	f, err := os.Create(name)
+	assert.NoError(t, err)
+	zw := zip.NewWriter(f)
+	w, _ := zw.Create("doc/readme.txt")
+	w.Write([]byte("hello"))
+	zw.Close()
+	f.Close()
+
+	assert.NoError(t, File(name, filepath.Join(dir, "out")))
+	content, err := os.ReadFile(filepath.Join(dir, "out", "doc", "readme.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+}
@@ -0,0 +1,154 @@
+package libtorrent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+	"github.com/ninehills/p2pfile/pkg/metainfo"
+	log "github.com/sirupsen/logrus"
+)
+
+// batchResumeFileName 是批量下载时 resume 文件的名称，位于 TorrentServer.DataDir 下
+const batchResumeFileName = ".p2pfile-batch.resume"
+
+// DownloadJob 是批量下载中的单个任务
+type DownloadJob struct {
+	// magnet uri、HTTP(S) torrent url 或本地 torrent 文件
+	Target string
+	// 下载路径
+	DataDir string
+}
+
+// task 是 session 中一个下载任务的运行状态
+type task struct {
+	job     DownloadJob
+	target  *target
+	torrent *torrent.Torrent
+	logger  log.FieldLogger
+	// 最近一次获取的状态及连接的最大 peer 数
+	stats    torrent.Stats
+	maxPeers int
+	// torrent 的存储路径，见 prepareDir
+	dir string
+	// dir 是 staging 或临时目录，任务结束后删除
+	staged bool
+	// 需要的数据已经下载完成
+	complete bool
+	// 文件已经移动到下载路径
+	finalized bool
+	// 文件已经解压
+	extracted bool
+	// 后台解压的结果，解压结束前非空，见 startExtract
+	extractResult chan error
+	// torrent 已经停止及停止的原因，解压结束后才结束任务，见 onStop
+	stopped bool
+	stopErr error
+	// 任务开始及下载完成的时间
+	startedAt   time.Time
+	completedAt time.Time
+	// 最近一次下载到数据的时间及当时已下载的字节数，用于检测下载停滞
+	progressAt time.Time
+	downloaded int64
+	// 下载完成前 tracker 最近一次 announce 的时间
+	announcedAt time.Time
+	// 任务被主动停止的原因，如超时
+	abortErr error
+	// 任务运行中出错的原因，如移动文件失败，优先于 abortErr
+	failErr error
+	// 任务已经停止
+	done bool
+	// 任务失败的原因，nil 表示正常结束
+	err error
+	// OnComplete 执行失败的原因，在 runHook 的 goroutine 中写入，见 waitHooks
+	hookErr error
+}
+
+// info 返回 torrent 的 metadata，magnet 在 metadata 下载完成前返回错误
+func (tk *task) info() (*metainfo.Info, error) {
+	if tk.target == nil {
+		return nil, fmt.Errorf("target %s is not resolved", tk.job.Target)
+	}
+	b := tk.target.torrent
+	if b == nil {
+		if tk.torrent == nil {
+			return nil, fmt.Errorf("torrent %s is not added", tk.target.name)
+		}
+		var err error
+		b, err = tk.torrent.Torrent()
+		if err != nil {
+			return nil, err
+		}
+	}
+	mi, err := metainfo.New(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return &mi.Info, nil
+}
+
+// ParseManifest reads download jobs from a manifest.
+//
+// Each line of manifest is `<TARGET> [DIR]`, blank lines and lines starting with `#` are ignored.
+// DIR is relative to baseDir unless it is absolute, and defaults to baseDir.
+func ParseManifest(r io.Reader, baseDir string) ([]DownloadJob, error) {
+	var jobs []DownloadJob
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("manifest line %d: expect `<TARGET> [DIR]`, got %q", lineNo, line)
+		}
+		job := DownloadJob{Target: fields[0], DataDir: baseDir}
+		if len(fields) == 2 {
+			if filepath.IsAbs(fields[1]) {
+				job.DataDir = fields[1]
+			} else {
+				job.DataDir = filepath.Join(baseDir, fields[1])
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (s *TorrentServer) jobs() []DownloadJob {
+	if len(s.Jobs) > 0 {
+		return s.Jobs
+	}
+	return []DownloadJob{{Target: s.Target, DataDir: s.DataDir}}
+}
+
+// resumeFile 单任务时为 <DataDir>/<name>.resume，批量下载时所有任务共享 <DataDir>/.p2pfile-batch.resume
+func (s *TorrentServer) resumeFile(tasks []*task) string {
+	if len(tasks) == 1 {
+		return filepath.Join(tasks[0].job.DataDir, tasks[0].target.name+".resume")
+	}
+	return filepath.Join(s.DataDir, batchResumeFileName)
+}
+
+// torrentID 返回 session 中唯一的 torrent ID。
+// session 的 DataDir 为空且开启了 DataDirIncludesTorrentID，所以 torrent ID 就是下载路径。
+// 同一路径的多个任务通过追加 "/." 区分，filepath.Join 会将其还原为同一路径。
+func torrentID(dir string, used map[string]bool) string {
+	id := filepath.Clean(dir)
+	for used[id] {
+		id += string(filepath.Separator) + "."
+	}
+	used[id] = true
+	return id
+}
@@ -0,0 +1,126 @@
+package libtorrent
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseManifest(t *testing.T) {
+	manifest := `
+# comment
+magnet:?xt=urn:btih:F60CC95E3566AF84C1AB223FD4CE80FA88E6438A
+http://example.com/a.torrent  amd64
+/tmp/b.torrent /opt/b
+`
+	jobs, err := ParseManifest(strings.NewReader(manifest), "data")
+	assert.NoError(t, err)
+	assert.Equal(t, []DownloadJob{
+		{Target: "magnet:?xt=urn:btih:F60CC95E3566AF84C1AB223FD4CE80FA88E6438A", DataDir: "data"},
+		{Target: "http://example.com/a.torrent", DataDir: "data/amd64"},
+		{Target: "/tmp/b.torrent", DataDir: "/opt/b"},
+	}, jobs)
+
+	_, err = ParseManifest(strings.NewReader("a b c"), "")
+	assert.Error(t, err)
+}
+
+func TestTorrentID(t *testing.T) {
+	used := make(map[string]bool)
+	assert.Equal(t, ".", torrentID("", used))
+	assert.Equal(t, "data", torrentID("data/", used))
+	assert.Equal(t, "data/.", torrentID("data", used))
+	assert.Equal(t, "data/./.", torrentID("data", used))
+}
+
+// newBatchJob 创建单文件 torrent 的任务。staged 为 true 时将数据写入 dataDir 下的 staging 目录，
+// 开启 resume 时 rain 校验已有的数据后直接完成下载，否则没有 peer 可以下载
+func newBatchJob(t *testing.T, dataDir, name string, staged bool) DownloadJob {
+	src := filepath.Join(t.TempDir(), name)
+	data := bytes.Repeat([]byte(name), 10000)
+	require.NoError(t, os.WriteFile(src, data, 0o644))
+	out := src + ".torrent"
+	_, err := CreateTorrent([]string{src}, out, "", "", false, 0, "", nil, nil)
+	require.NoError(t, err)
+	if staged {
+		target, err := resolveTarget(context.Background(), out, FetchOptions{})
+		require.NoError(t, err)
+		dir := stagingDir(dataDir, target.infoHash)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o644))
+	}
+	return DownloadJob{Target: out, DataDir: dataDir}
+}
+
+func TestBatchFailure(t *testing.T) {
+	base := t.TempDir()
+	dirs := []string{filepath.Join(base, "a"), filepath.Join(base, "b"), filepath.Join(base, "c")}
+	jobs := []DownloadJob{
+		newBatchJob(t, dirs[0], "a.bin", true),
+		newBatchJob(t, dirs[1], "b.bin", false),
+		{Target: "magnet:?xt=urn:btih:zz", DataDir: dirs[2]},
+	}
+	s := &TorrentServer{Jobs: jobs, DataDir: base, IsResume: true, Timeout: 3 * time.Second}
+	res, err := s.RunContext(context.Background())
+	// 退出码由按任务顺序第一个失败的任务决定，而不是最先失败的任务
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.NotErrorIs(t, err, ErrInvalidTarget)
+	require.NotNil(t, res)
+	require.Len(t, res.Tasks, 3)
+	for i, r := range res.Tasks {
+		assert.Equal(t, jobs[i].Target, r.Target)
+		assert.Equal(t, jobs[i].DataDir, r.DataDir)
+	}
+	assert.NoError(t, res.Tasks[0].Err)
+	assert.Equal(t, filepath.Join(dirs[0], "a.bin"), res.Tasks[0].Path)
+	assert.FileExists(t, res.Tasks[0].Path)
+	assert.ErrorIs(t, res.Tasks[1].Err, ErrTimeout)
+	assert.Empty(t, res.Tasks[1].Path)
+	assert.ErrorIs(t, res.Tasks[2].Err, ErrInvalidTarget)
+	assert.Len(t, res.Failed(), 2)
+
+	// 所有任务共享 DataDir 下的 resume 文件，失败时保留
+	assert.FileExists(t, filepath.Join(base, batchResumeFileName))
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.resume"))
+		assert.Empty(t, matches)
+	}
+
+	// 调整顺序后由无效的 target 决定退出码
+	s = &TorrentServer{Jobs: []DownloadJob{jobs[2], jobs[1]}, DataDir: base, IsResume: true, Timeout: 2 * time.Second}
+	res, err = s.RunContext(context.Background())
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+	assert.NotErrorIs(t, err, ErrTimeout)
+	if assert.NotNil(t, res) && assert.Len(t, res.Tasks, 2) {
+		assert.ErrorIs(t, res.Tasks[1].Err, ErrTimeout)
+	}
+}
+
+func TestBatchSameDir(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "data")
+	jobs := []DownloadJob{
+		newBatchJob(t, dir, "a.bin", true),
+		newBatchJob(t, dir, "b.bin", true),
+	}
+	s := &TorrentServer{Jobs: jobs, DataDir: base, IsResume: true}
+	res, err := s.RunContext(context.Background())
+	require.NoError(t, err)
+	require.Len(t, res.Tasks, 2)
+	for i, name := range []string{"a.bin", "b.bin"} {
+		assert.NoError(t, res.Tasks[i].Err)
+		assert.Equal(t, filepath.Join(dir, name), res.Tasks[i].Path)
+		assert.FileExists(t, res.Tasks[i].Path)
+	}
+	// 下载成功后删除 resume 文件和 staging 目录
+	assert.NoFileExists(t, filepath.Join(base, batchResumeFileName))
+	matches, _ := filepath.Glob(filepath.Join(dir, ".p2pfile-*"))
+	assert.Empty(t, matches)
+}
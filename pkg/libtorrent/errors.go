@@ -0,0 +1,52 @@
+package libtorrent
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// Run 返回的错误包装以下类型，调用方通过 errors.Is 判断失败原因。批量下载时包装第一个失败的任务的错误
+var (
+	// ErrInvalidTarget 表示 magnet uri 或 torrent 文件无效
+	ErrInvalidTarget = errors.New("invalid target")
+	// ErrTimeout 表示任务没有在 TorrentServer.Timeout 内下载完成
+	ErrTimeout = errors.New("download timeout")
+	// ErrStalled 表示任务在 TorrentServer.StallTimeout 内没有下载到数据
+	ErrStalled = errors.New("download stalled")
+	// ErrMetadataTimeout 表示 magnet 任务在 TorrentServer.StallTimeout 内没有获取到 metadata
+	ErrMetadataTimeout = errors.New("metadata timeout")
+	// ErrTrackerUnreachable 表示任务超时或停滞时所有 tracker 都无法访问
+	ErrTrackerUnreachable = errors.New("tracker unreachable")
+	// ErrDiskFull 表示磁盘空间不足
+	ErrDiskFull = errors.New("disk full")
+	// ErrVerification 表示做种（IsServe）的文件和 torrent 不一致。
+	// 下载时校验失败的 piece 由 rain 重新下载，不返回该错误
+	ErrVerification = errors.New("verification failed")
+	// ErrInterrupted 表示任务被 SIGINT 或 SIGTERM 中断
+	ErrInterrupted = errors.New("interrupted")
+)
+
+// classifyError 识别 rain 及文件系统返回的错误。rain 写入 piece 失败时返回原始的 *os.PathError
+func classifyError(err error) error {
+	if err == nil || errors.Is(err, ErrDiskFull) {
+		return err
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %s", ErrDiskFull, err)
+	}
+	return err
+}
+
+// trackerUnreachable 判断任务的所有 tracker 是否都无法访问
+func trackerUnreachable(t *torrent.Torrent) bool {
+	trackers := t.Trackers()
+	for _, tr := range trackers {
+		if tr.Status != torrent.NotWorking {
+			return false
+		}
+	}
+	return len(trackers) > 0
+}
@@ -0,0 +1,40 @@
+package libtorrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	assert.Nil(t, classifyError(nil))
+
+	err := classifyError(&os.PathError{Op: "write", Path: "a.bin", Err: syscall.ENOSPC})
+	assert.ErrorIs(t, err, ErrDiskFull)
+	assert.Equal(t, err, classifyError(err))
+	err = classifyError(fmt.Errorf("write piece: %w", syscall.ENOSPC))
+	assert.ErrorIs(t, err, ErrDiskFull)
+	// 只识别包装了 ENOSPC 的错误
+	err = errors.New("allocate a.bin: " + syscall.ENOSPC.Error())
+	assert.Equal(t, err, classifyError(err))
+
+	err = fmt.Errorf("%w: not completed in 1s", ErrTimeout)
+	assert.Equal(t, err, classifyError(err))
+}
+
+func TestResolveInvalidTarget(t *testing.T) {
+	_, err := resolveTarget(context.Background(), "magnet:?xt=urn:btih:zz", FetchOptions{})
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	f, err := os.CreateTemp(t.TempDir(), "*.torrent")
+	assert.NoError(t, err)
+	f.WriteString("not a torrent")
+	f.Close()
+	_, err = resolveTarget(context.Background(), f.Name(), FetchOptions{})
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+}
@@ -0,0 +1,65 @@
+package libtorrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ninehills/p2pfile/pkg/extract"
+)
+
+// ExtractOptions 控制下载完成后解压 tar、tar.gz、tar.zst 或 zip 格式的单文件 torrent
+type ExtractOptions struct {
+	// 解压路径，为空时不解压
+	Dir string
+	// 解压成功后删除压缩文件
+	Delete bool
+}
+
+// startExtract 在后台解压，避免阻塞 wait 中其他任务的检查，结果见 pollExtract
+func (tk *task) startExtract(opt ExtractOptions) {
+	result := make(chan error, 1)
+	tk.extractResult = result
+	go func() {
+		result <- tk.extract(opt)
+	}()
+}
+
+// pollExtract 检查解压是否结束，返回解压失败的原因。解压结束后 tk.extractResult 为 nil
+func (tk *task) pollExtract() error {
+	if tk.extractResult == nil {
+		return nil
+	}
+	select {
+	case err := <-tk.extractResult:
+		tk.extractResult = nil
+		tk.extracted = err == nil
+		return err
+	default:
+		return nil
+	}
+}
+
+// extract 解压已经移动到下载路径的文件，在 startExtract 的 goroutine 中执行，只读取 task 的字段
+func (tk *task) extract(opt ExtractOptions) error {
+	info, err := tk.info()
+	if err != nil {
+		return err
+	}
+	if len(info.Files) != 1 {
+		return fmt.Errorf("torrent %s has %d files, only single file torrent can be extracted", info.Name, len(info.Files))
+	}
+	archive := filepath.Join(tk.job.DataDir, info.Files[0].Path)
+	tk.logger.Infof("Extracting %s to %s", archive, opt.Dir)
+	if err := os.MkdirAll(opt.Dir, 0o755); err != nil {
+		return err
+	}
+	if err := extract.File(archive, opt.Dir); err != nil {
+		return fmt.Errorf("extract %s: %w", archive, err)
+	}
+	if opt.Delete {
+		tk.logger.Infof("Remove archive %s", archive)
+		return os.Remove(archive)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package libtorrent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultFetchTimeout is the default timeout for fetching a .torrent file over HTTP(S).
+	DefaultFetchTimeout = 30 * time.Second
+	// DefaultMaxTorrentSize is the default max size of a .torrent file, 10 MiB.
+	DefaultMaxTorrentSize = 10 << 20
+)
+
+// FetchOptions 控制通过 HTTP(S) 获取 .torrent 文件的行为
+type FetchOptions struct {
+	// 整个请求的超时时间，为 0 时使用 DefaultFetchTimeout
+	Timeout time.Duration
+	// .torrent 文件的最大字节数，为 0 时使用 DefaultMaxTorrentSize
+	MaxSize int64
+	// PEM 格式的 CA 证书文件，用于校验内部 HTTPS 服务的证书。为空时只使用系统证书
+	CAFile string
+}
+
+// FetchTorrent downloads a .torrent file from a HTTP(S) url. The request is canceled when ctx is done.
+func FetchTorrent(ctx context.Context, url string, opt FetchOptions) ([]byte, error) {
+	if opt.Timeout <= 0 {
+		opt.Timeout = DefaultFetchTimeout
+	}
+	if opt.MaxSize <= 0 {
+		opt.MaxSize = DefaultMaxTorrentSize
+	}
+	client := &http.Client{
+		Timeout: opt.Timeout,
+	}
+	if opt.CAFile != "" {
+		pool, err := loadCertPool(opt.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.Transport = transport
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch torrent %s: unexpected status %s", url, resp.Status)
+	}
+	if resp.ContentLength > opt.MaxSize {
+		return nil, fmt.Errorf("fetch torrent %s: torrent too large: %d bytes (max %d)", url, resp.ContentLength, opt.MaxSize)
+	}
+	// 多读一个字节用于判断是否超出限制
+	b, err := io.ReadAll(io.LimitReader(resp.Body, opt.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > opt.MaxSize {
+		return nil, fmt.Errorf("fetch torrent %s: torrent too large: more than %d bytes", url, opt.MaxSize)
+	}
+	return b, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid certificate found in ca file: %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,55 @@
+package libtorrent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchTorrent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.torrent":
+			w.Write([]byte("d4:infod4:name3:fooee"))
+		case "/large.torrent":
+			w.Write([]byte(strings.Repeat("x", 2048)))
+		case "/slow.torrent":
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	b, err := FetchTorrent(context.Background(), ts.URL+"/ok.torrent", FetchOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "d4:infod4:name3:fooee", string(b))
+
+	_, err = FetchTorrent(context.Background(), ts.URL+"/large.torrent", FetchOptions{MaxSize: 1024})
+	assert.Error(t, err)
+
+	_, err = FetchTorrent(context.Background(), ts.URL+"/missing.torrent", FetchOptions{})
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = FetchTorrent(ctx, ts.URL+"/slow.torrent", FetchOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestRunContextFetchTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+	s := &TorrentServer{Target: ts.URL + "/slow.torrent", DataDir: t.TempDir(), Timeout: 200 * time.Millisecond}
+	_, err := s.RunContext(context.Background())
+	assert.ErrorIs(t, err, ErrTimeout)
+}
@@ -0,0 +1,136 @@
+package libtorrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+	"github.com/ninehills/p2pfile/pkg/metainfo"
+)
+
+// stagingDir 是下载时 torrent 的存储路径，所有 piece 校验通过后文件才会被移动到下载路径，
+// 避免其他程序读取到未下载完成的文件。
+func stagingDir(dataDir string, ih torrent.InfoHash) string {
+	return filepath.Join(dataDir, ".p2pfile-"+ih.String())
+}
+
+// checkComplete 判断任务需要的数据是否已经下载并校验完成，完成后将文件移动到下载路径
+func (s *TorrentServer) checkComplete(tk *task) error {
+	if tk.finalized {
+		return tk.pollExtract()
+	}
+	if !tk.complete {
+		// rain 在所有 piece 通过 SHA1 校验后关闭 NotifyComplete 返回的 channel
+		select {
+		case <-tk.torrent.NotifyComplete():
+			tk.complete = true
+		default:
+			return nil
+		}
+	}
+	if tk.completedAt.IsZero() {
+		tk.completedAt = time.Now()
+	}
+	if !tk.staged {
+		return nil
+	}
+	if err := tk.finalize(s.Fsync); err != nil {
+		return err
+	}
+	if s.Extract.Dir != "" {
+		tk.startExtract(s.Extract)
+	}
+	return nil
+}
+
+// finalize 将文件从 staging 目录重命名到下载路径。
+//
+// 目标不存在（或为单文件）时，直接重命名 torrent 的顶层文件或目录，否则逐个文件重命名。
+// 做种时 rain 仍持有已打开的文件，重命名后可以继续读取。
+func (tk *task) finalize(fsync bool) error {
+	info, err := tk.info()
+	if err != nil {
+		return err
+	}
+	if fsync {
+		for _, f := range info.Files {
+			if err := syncPath(filepath.Join(tk.dir, f.Path)); err != nil {
+				return err
+			}
+		}
+	}
+
+	var moved []string
+	root := rootName(info)
+	rootDst := filepath.Join(tk.job.DataDir, root)
+	if _, err := os.Stat(rootDst); len(info.Files) == 1 || os.IsNotExist(err) {
+		if err := os.Rename(filepath.Join(tk.dir, root), rootDst); err != nil {
+			return err
+		}
+		moved = append(moved, rootDst)
+	} else {
+		for _, f := range info.Files {
+			dst := filepath.Join(tk.job.DataDir, f.Path)
+			if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+				return err
+			}
+			if err := os.Rename(filepath.Join(tk.dir, f.Path), dst); err != nil {
+				return err
+			}
+			moved = append(moved, dst)
+		}
+	}
+	if fsync {
+		synced := make(map[string]bool)
+		for _, p := range moved {
+			dir := filepath.Dir(p)
+			if synced[dir] {
+				continue
+			}
+			synced[dir] = true
+			if err := syncPath(dir); err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range moved {
+		tk.logger.Infof("Saved %s", p)
+	}
+	tk.finalized = true
+	return nil
+}
+
+// cleanup 删除 staging 目录。下载失败时只有开启了 resume 才会保留未完成的数据
+func (s *TorrentServer) cleanup(tasks []*task) {
+	for _, tk := range tasks {
+		if !tk.staged || (s.IsResume && !tk.finalized) {
+			continue
+		}
+		if !tk.finalized {
+			s.logger.Infof("Remove partial download: %s", tk.dir)
+		}
+		if err := os.RemoveAll(tk.dir); err != nil {
+			s.logger.Warnf("Failed to remove %s: %s", tk.dir, err)
+		}
+	}
+}
+
+// rootName 返回 torrent 在下载路径下的顶层文件或目录名称
+func rootName(info *metainfo.Info) string {
+	return strings.SplitN(filepath.ToSlash(info.Files[0].Path), "/", 2)[0]
+}
+
+func syncPath(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("fsync %s: %w", name, err)
+	}
+	return nil
+}
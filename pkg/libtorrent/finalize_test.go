@@ -0,0 +1,195 @@
+package libtorrent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// newStagedTask 为 files（相对路径 -> 内容）创建 torrent，并将数据写入下载路径下的 staging 目录，
+// 相当于 rain 已经下载并校验完成。files 只有一个文件且没有目录时为单文件 torrent
+func newStagedTask(t *testing.T, name string, files map[string]string) *task {
+	src := filepath.Join(t.TempDir(), name)
+	if content, ok := files[""]; ok {
+		require.NoError(t, os.WriteFile(src, []byte(content), 0o644))
+	} else {
+		for p, content := range files {
+			require.NoError(t, os.MkdirAll(filepath.Join(src, filepath.Dir(p)), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(src, p), []byte(content), 0o644))
+		}
+	}
+	out := filepath.Join(t.TempDir(), name+".torrent")
+	_, err := CreateTorrent([]string{src}, out, "", "", false, 0, "", nil, nil)
+	require.NoError(t, err)
+	target, err := resolveTarget(context.Background(), out, FetchOptions{})
+	require.NoError(t, err)
+
+	dataDir := t.TempDir()
+	tk := &task{
+		job:       DownloadJob{Target: out, DataDir: dataDir},
+		target:    target,
+		logger:    log.StandardLogger(),
+		dir:       stagingDir(dataDir, target.infoHash),
+		staged:    true,
+		startedAt: time.Now(),
+	}
+	for p, content := range files {
+		dst := filepath.Join(tk.dir, name, p)
+		require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0o755))
+		require.NoError(t, os.WriteFile(dst, []byte(content), 0o644))
+	}
+	return tk
+}
+
+func readFile(t *testing.T, name string) string {
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestStagingDir(t *testing.T) {
+	tk := newStagedTask(t, "data.bin", map[string]string{"": "data"})
+	assert.Equal(t, filepath.Join(tk.job.DataDir, ".p2pfile-"+tk.target.infoHash.String()), tk.dir)
+	assert.Equal(t, filepath.Join("data", ".p2pfile-"+tk.target.infoHash.String()), stagingDir("data", tk.target.infoHash))
+}
+
+func TestFinalize(t *testing.T) {
+	cases := []struct {
+		name  string
+		root  string
+		files map[string]string
+		// 下载路径中已经存在的文件
+		existing map[string]string
+		fsync    bool
+		// 下载路径中期望的文件
+		want map[string]string
+	}{
+		{
+			name:  "single file",
+			root:  "data.bin",
+			files: map[string]string{"": "new"},
+			want:  map[string]string{"data.bin": "new"},
+		},
+		{
+			name:     "single file exists",
+			root:     "data.bin",
+			files:    map[string]string{"": "new"},
+			existing: map[string]string{"data.bin": "old"},
+			fsync:    true,
+			want:     map[string]string{"data.bin": "new"},
+		},
+		{
+			name:  "multi file",
+			root:  "dir",
+			files: map[string]string{"a.txt": "a", "sub/b.txt": "b"},
+			fsync: true,
+			want:  map[string]string{"dir/a.txt": "a", "dir/sub/b.txt": "b"},
+		},
+		{
+			// 目标目录已经存在时逐个文件重命名，保留其他文件
+			name:     "multi file exists",
+			root:     "dir",
+			files:    map[string]string{"a.txt": "a", "sub/b.txt": "b"},
+			existing: map[string]string{"dir/a.txt": "old", "dir/keep.txt": "keep"},
+			want:     map[string]string{"dir/a.txt": "a", "dir/sub/b.txt": "b", "dir/keep.txt": "keep"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tk := newStagedTask(t, c.root, c.files)
+			for p, content := range c.existing {
+				name := filepath.Join(tk.job.DataDir, p)
+				require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+				require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+			}
+			require.NoError(t, tk.finalize(c.fsync))
+			assert.True(t, tk.finalized)
+			for p, content := range c.want {
+				assert.Equal(t, content, readFile(t, filepath.Join(tk.job.DataDir, p)), p)
+			}
+		})
+	}
+}
+
+func TestCheckComplete(t *testing.T) {
+	s := &TorrentServer{logger: log.StandardLogger()}
+	tk := newStagedTask(t, "data.bin", map[string]string{"": "data"})
+	tk.complete = true
+	require.NoError(t, s.checkComplete(tk))
+	assert.True(t, tk.finalized)
+	assert.False(t, tk.completedAt.IsZero())
+	assert.Equal(t, "data", readFile(t, filepath.Join(tk.job.DataDir, "data.bin")))
+	// 已经移动到下载路径后不再处理
+	require.NoError(t, s.checkComplete(tk))
+
+	// 做种时直接下载到下载路径，不需要移动
+	tk = newStagedTask(t, "data.bin", map[string]string{"": "data"})
+	tk.staged = false
+	tk.complete = true
+	require.NoError(t, s.checkComplete(tk))
+	assert.False(t, tk.finalized)
+	assert.False(t, tk.completedAt.IsZero())
+
+	// staging 目录中的文件不存在时返回错误
+	tk = newStagedTask(t, "data.bin", map[string]string{"": "data"})
+	tk.complete = true
+	require.NoError(t, os.RemoveAll(tk.dir))
+	assert.Error(t, s.checkComplete(tk))
+	assert.False(t, tk.finalized)
+}
+
+func TestCleanup(t *testing.T) {
+	cases := []struct {
+		name      string
+		staged    bool
+		finalized bool
+		resume    bool
+		removed   bool
+	}{
+		{name: "finalized", staged: true, finalized: true, removed: true},
+		{name: "finalized resume", staged: true, finalized: true, resume: true, removed: true},
+		{name: "failed", staged: true, removed: true},
+		// 开启 resume 时保留未完成的数据，下次下载时继续
+		{name: "failed resume", staged: true, resume: true},
+		{name: "not staged", finalized: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tk := newStagedTask(t, "data.bin", map[string]string{"": "data"})
+			tk.staged = c.staged
+			tk.finalized = c.finalized
+			s := &TorrentServer{IsResume: c.resume, logger: log.StandardLogger()}
+			s.cleanup([]*task{tk})
+			_, err := os.Stat(tk.dir)
+			assert.Equal(t, c.removed, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestRootName(t *testing.T) {
+	tk := newStagedTask(t, "data.bin", map[string]string{"": "data"})
+	info, err := tk.info()
+	require.NoError(t, err)
+	assert.Equal(t, "data.bin", rootName(info))
+
+	tk = newStagedTask(t, "dir", map[string]string{"sub/a.txt": "a", "b.txt": "b"})
+	info, err = tk.info()
+	require.NoError(t, err)
+	assert.Equal(t, "dir", rootName(info))
+}
+
+func TestSyncPath(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	require.NoError(t, os.WriteFile(name, []byte("a"), 0o644))
+	assert.NoError(t, syncPath(name))
+	assert.NoError(t, syncPath(dir))
+	assert.Error(t, syncPath(filepath.Join(dir, "missing")))
+}
@@ -0,0 +1,112 @@
+package libtorrent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Hooks 是任务结束后通过 `sh -c` 执行的命令，任务信息通过以下环境变量传递：
+//
+//	P2PFILE_TARGET    magnet uri、torrent url 或 torrent 文件
+//	P2PFILE_DIR       下载路径
+//	P2PFILE_NAME      torrent 名称
+//	P2PFILE_PATH      下载完成的文件或目录
+//	P2PFILE_INFOHASH  info hash
+//	P2PFILE_SIZE      torrent 的大小，单位为字节
+//	P2PFILE_DOWNLOADED 从其他节点下载的字节数
+//	P2PFILE_DURATION  下载耗时，单位为秒
+//	P2PFILE_SPEED     平均下载速度，单位为字节每秒
+//	P2PFILE_EXTRACT_DIR 解压路径，只在解压成功时设置
+//	P2PFILE_ERROR     失败原因，只用于 OnError
+type Hooks struct {
+	// 任务成功后执行，执行失败时任务也视为失败
+	OnComplete string
+	// 任务失败后执行
+	OnError string
+}
+
+// runHook 根据任务结果在后台执行 OnComplete 或 OnError，避免阻塞 wait 中其他任务的检查。
+// 命令由 s.hooks 跟踪，OnComplete 的结果见 waitHooks
+func (s *TorrentServer) runHook(tk *task) {
+	command := s.Hooks.OnComplete
+	failed := tk.err != nil
+	if failed {
+		command = s.Hooks.OnError
+	}
+	if command == "" {
+		return
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), tk.hookEnv(s)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	s.logger.Infof("Running hook for %s: %s", tk.job.Target, command)
+	s.hooks.Add(1)
+	go func() {
+		defer s.hooks.Done()
+		err := cmd.Run()
+		if err == nil {
+			return
+		}
+		if failed {
+			s.logger.Errorf("On error hook failed: %s", err)
+			return
+		}
+		tk.hookErr = err
+	}()
+}
+
+// waitHooks 等待所有命令执行结束，OnComplete 执行失败的任务也视为失败
+func (s *TorrentServer) waitHooks(tasks []*task) {
+	s.hooks.Wait()
+	for _, tk := range tasks {
+		if tk.hookErr == nil || tk.err != nil {
+			continue
+		}
+		tk.err = fmt.Errorf("on complete hook failed: %w", tk.hookErr)
+		s.logger.Errorf("%s", tk.err)
+	}
+}
+
+func (tk *task) hookEnv(s *TorrentServer) []string {
+	env := map[string]string{
+		"P2PFILE_TARGET": tk.job.Target,
+		"P2PFILE_DIR":    tk.job.DataDir,
+	}
+	if tk.target != nil {
+		env["P2PFILE_NAME"] = tk.target.name
+		env["P2PFILE_INFOHASH"] = tk.target.infoHash.String()
+	}
+	end := tk.completedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	duration := end.Sub(tk.startedAt)
+	env["P2PFILE_DURATION"] = fmt.Sprintf("%.3f", duration.Seconds())
+	if tk.torrent != nil {
+		downloaded := tk.stats.Bytes.Downloaded
+		env["P2PFILE_DOWNLOADED"] = fmt.Sprint(downloaded)
+		if duration > 0 {
+			env["P2PFILE_SPEED"] = fmt.Sprint(int64(float64(downloaded) / duration.Seconds()))
+		}
+	}
+	if info, err := tk.info(); err == nil {
+		env["P2PFILE_SIZE"] = fmt.Sprint(info.Length)
+	}
+	if p := tk.path(); p != "" {
+		env["P2PFILE_PATH"] = p
+	}
+	if tk.extracted {
+		env["P2PFILE_EXTRACT_DIR"] = s.Extract.Dir
+	}
+	if tk.err != nil {
+		env["P2PFILE_ERROR"] = tk.err.Error()
+	}
+	vars := make([]string, 0, len(env))
+	for k, v := range env {
+		vars = append(vars, k+"="+v)
+	}
+	return vars
+}
@@ -0,0 +1,144 @@
+package libtorrent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// newTestTask 返回已经下载完成的单文件 torrent 的任务
+func newTestTask(t *testing.T) *task {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "data.bin")
+	require.NoError(t, os.WriteFile(name, make([]byte, 100000), 0o644))
+	out := filepath.Join(dir, "data.torrent")
+	_, err := CreateTorrent([]string{name}, out, dir, "", false, 0, "", nil, nil)
+	require.NoError(t, err)
+	target, err := resolveTarget(context.Background(), out, FetchOptions{})
+	require.NoError(t, err)
+	now := time.Now()
+	return &task{
+		job:         DownloadJob{Target: out, DataDir: dir},
+		target:      target,
+		logger:      log.StandardLogger(),
+		complete:    true,
+		finalized:   true,
+		startedAt:   now.Add(-2 * time.Second),
+		completedAt: now,
+	}
+}
+
+func envMap(vars []string) map[string]string {
+	env := make(map[string]string)
+	for _, v := range vars {
+		kv := strings.SplitN(v, "=", 2)
+		env[kv[0]] = kv[1]
+	}
+	return env
+}
+
+func TestHookEnv(t *testing.T) {
+	s := &TorrentServer{Extract: ExtractOptions{Dir: "out"}}
+	tk := newTestTask(t)
+	env := envMap(tk.hookEnv(s))
+	assert.Equal(t, tk.job.Target, env["P2PFILE_TARGET"])
+	assert.Equal(t, tk.job.DataDir, env["P2PFILE_DIR"])
+	assert.Equal(t, "data.bin", env["P2PFILE_NAME"])
+	assert.Equal(t, tk.target.infoHash.String(), env["P2PFILE_INFOHASH"])
+	assert.Equal(t, "100000", env["P2PFILE_SIZE"])
+	assert.Equal(t, filepath.Join(tk.job.DataDir, "data.bin"), env["P2PFILE_PATH"])
+	assert.Equal(t, "2.000", env["P2PFILE_DURATION"])
+	assert.NotContains(t, env, "P2PFILE_EXTRACT_DIR")
+	assert.NotContains(t, env, "P2PFILE_ERROR")
+
+	tk.extracted = true
+	assert.Equal(t, "out", envMap(tk.hookEnv(s))["P2PFILE_EXTRACT_DIR"])
+
+	// 解析失败的任务只有 target、下载路径和失败原因
+	tk = &task{job: DownloadJob{Target: "bad", DataDir: "data"}, startedAt: time.Now(), err: ErrInvalidTarget}
+	env = envMap(tk.hookEnv(s))
+	assert.Equal(t, "bad", env["P2PFILE_TARGET"])
+	assert.Equal(t, ErrInvalidTarget.Error(), env["P2PFILE_ERROR"])
+	assert.NotContains(t, env, "P2PFILE_NAME")
+	assert.NotContains(t, env, "P2PFILE_SIZE")
+	assert.NotContains(t, env, "P2PFILE_PATH")
+}
+
+func TestRunHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hook.out")
+	s := &TorrentServer{
+		Hooks: Hooks{
+			OnComplete: `echo "complete $P2PFILE_NAME" > ` + out,
+			OnError:    `echo "error $P2PFILE_ERROR" > ` + out,
+		},
+		logger: log.StandardLogger(),
+	}
+	read := func() string {
+		b, err := os.ReadFile(out)
+		require.NoError(t, err)
+		return strings.TrimSpace(string(b))
+	}
+
+	tk := newTestTask(t)
+	tasks := []*task{tk}
+	s.runHook(tk)
+	s.waitHooks(tasks)
+	assert.NoError(t, tk.err)
+	assert.Equal(t, "complete data.bin", read())
+
+	tk.err = errors.New("boom")
+	s.runHook(tk)
+	s.waitHooks(tasks)
+	assert.Equal(t, "error boom", read())
+
+	// OnComplete 失败时任务也失败，OnError 失败不影响失败原因
+	s.Hooks = Hooks{OnComplete: "exit 3", OnError: "exit 4"}
+	tk.err = nil
+	s.runHook(tk)
+	s.waitHooks(tasks)
+	assert.EqualError(t, tk.err, "on complete hook failed: exit status 3")
+	s.runHook(tk)
+	s.waitHooks(tasks)
+	assert.EqualError(t, tk.err, "on complete hook failed: exit status 3")
+}
+
+func TestRunHookAsync(t *testing.T) {
+	s := &TorrentServer{Hooks: Hooks{OnComplete: "sleep 1"}, logger: log.StandardLogger()}
+	tk := newTestTask(t)
+	start := time.Now()
+	s.runHook(tk)
+	// runHook 不等待命令结束
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	s.waitHooks([]*task{tk})
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.NoError(t, tk.err)
+}
+
+func TestRunContextErrorHook(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "hook.out")
+	tk := newTestTask(t)
+	// 下载路径是文件，无法创建 resume 文件和 staging 目录
+	dataDir := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(dataDir, nil, 0o644))
+	s := &TorrentServer{
+		Target:  tk.job.Target,
+		DataDir: dataDir,
+		Hooks:   Hooks{OnError: `echo "$P2PFILE_TARGET" > ` + out},
+	}
+	_, err := s.RunContext(context.Background())
+	assert.Error(t, err)
+	b, err := os.ReadFile(out)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tk.job.Target+"\n", string(b))
+	}
+}
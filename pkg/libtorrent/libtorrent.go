@@ -4,17 +4,16 @@
 package libtorrent
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
-	"os/signal"
-	"path"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/rain/torrent"
-	"github.com/ninehills/p2pfile/pkg/magnet"
 	"github.com/ninehills/p2pfile/pkg/metainfo"
 	log "github.com/sirupsen/logrus"
 )
@@ -23,6 +22,8 @@ type TorrentServer struct {
 	Target string
 	// 下载路径
 	DataDir string
+	// 批量下载的任务，在同一个 session 中并发下载，共享带宽限制。非空时忽略 Target 和 DataDir
+	Jobs []DownloadJob
 	// 是否为做种节点，如是则调大连接数等参数。
 	IsServe bool
 	// 是否通过 *.resume 文件恢复下载
@@ -37,15 +38,51 @@ type TorrentServer struct {
 	SpeedLimitDownload float64
 	// Global upload speed limit in MB/s.
 	SpeedLimitUpload float64
+	// Target 为 HTTP(S) 地址时，获取 .torrent 文件的参数
+	Fetch FetchOptions
+	// 下载完成后，移动到下载路径前对文件执行 fsync
+	Fsync bool
+	// 任务结束后执行的命令
+	Hooks Hooks
+	// 下载完成后解压，只支持单个任务
+	Extract ExtractOptions
+	// 下载的最长时间，从 RunContext 开始计时，包括获取 torrent 文件和 metadata 的时间。
+	// 超过后停止未完成的任务并返回 ErrTimeout，已经完成的任务继续做种。0 表示不限制
+	Timeout time.Duration
+	// 超过该时间没有获取到 metadata 或没有下载到数据时，停止任务并返回 ErrStalled。0 表示不限制
+	StallTimeout time.Duration
+	// 每秒以各个任务的进度调用一次，在 RunContext 的 goroutine 中执行，不能阻塞
+	Progress func(Progress)
+	// 日志输出，为空时使用 logrus 的全局 logger。rain 的日志不受影响，仍然输出到 stderr
+	Logger log.FieldLogger
+
+	logger log.FieldLogger
+	// 执行中的 Hooks，RunContext 返回前等待其结束
+	hooks sync.WaitGroup
 }
 
+// Run 等价于 RunContext(context.Background())，忽略下载结果
 func (s *TorrentServer) Run() error {
-	log.Infof("Starting torrent server with config: %+v", s)
+	_, err := s.RunContext(context.Background())
+	return err
+}
+
+// RunContext 下载或做种，直到所有任务结束或 ctx 被取消，不处理信号。
+//
+// ctx 被取消时停止未完成的任务并返回 ErrInterrupted，超过 ctx 的 deadline 时返回 ErrTimeout。
+// 参数校验通过后 Result 总是非空，即使返回错误。
+func (s *TorrentServer) RunContext(ctx context.Context) (res *Result, err error) {
+	s.logger = s.Logger
+	if s.logger == nil {
+		s.logger = log.StandardLogger()
+	}
+	s.logger.Infof("Starting torrent server with config: %+v", s)
 	cfg := torrent.DefaultConfig
 	cfg.RPCEnabled = false
 	cfg.DHTEnabled = false
-	cfg.DataDir = s.DataDir
-	cfg.DataDirIncludesTorrentID = false
+	// 每个任务的下载路径不同，所以 DataDir 为空，并将下载路径作为 torrent ID，见 torrentID
+	cfg.DataDir = ""
+	cfg.DataDirIncludesTorrentID = true
 	cfg.SpeedLimitDownload = int64(s.SpeedLimitDownload * 1024)
 	cfg.SpeedLimitUpload = int64(s.SpeedLimitUpload * 1024)
 
@@ -62,148 +99,426 @@ func (s *TorrentServer) Run() error {
 		cfg.MaxPeerAddresses = 20000
 
 		if s.SeedingAutoStop {
-			return fmt.Errorf("seedingAutoStop can't be true when isServe is true")
+			return nil, fmt.Errorf("seedingAutoStop can't be true when isServe is true")
 		}
 	}
-	enableSeeding := s.IsServe || s.MaxSeedingSeconds > 0
-
-	var ih torrent.InfoHash
-	var resumeFileName string
-	if isURI(s.Target) {
-		magnet, err := magnet.New(s.Target)
-		if err != nil {
-			return err
+	jobs := s.jobs()
+	// 多个任务解压到同一个路径时文件会互相覆盖
+	if s.Extract.Dir != "" && len(jobs) > 1 {
+		return nil, fmt.Errorf("extract can't be used with multiple targets")
+	}
+	tasks := make([]*task, len(jobs))
+	start := time.Now()
+	// 以下 return 的 Result 均由任务的状态生成
+	defer func() {
+		res = s.result(tasks, time.Since(start))
+	}()
+	// 在生成 Result 前执行，提前返回时也等待 Hooks 结束
+	defer s.waitHooks(tasks)
+	// Timeout 从这里开始计时，包括获取 torrent 文件和 metadata 的时间
+	runCtx := ctx
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	for i, job := range jobs {
+		tasks[i] = &task{job: job, logger: s.logger, startedAt: time.Now()}
+		if runCtx.Err() != nil {
+			s.fail(tasks[i], s.stopReason(ctx))
+			if len(jobs) == 1 {
+				return nil, tasks[i].err
+			}
+			continue
 		}
-		ih = torrent.InfoHash(magnet.InfoHash)
-		resumeFileName = magnet.Name + ".resume"
-	} else {
-		f, err := os.Open(s.Target)
-		if err != nil {
-			return err
+		if isHTTPURL(job.Target) {
+			s.logger.Infof("Fetching torrent file from %s", job.Target)
+		}
+		tasks[i].target, err = resolveTarget(runCtx, job.Target, s.Fetch)
+		if err != nil && runCtx.Err() != nil {
+			err = fmt.Errorf("%w: %s", s.stopReason(ctx), err)
 		}
-		defer f.Close()
-		mi, err := metainfo.New(f)
 		if err != nil {
-			return err
+			s.logger.Errorf("Failed to resolve %s: %s", job.Target, err)
+			s.fail(tasks[i], err)
+			if len(jobs) == 1 {
+				return nil, tasks[i].err
+			}
 		}
-		_ = f.Close()
-		ih = mi.Info.Hash
-		resumeFileName = mi.Info.Name + ".resume"
 	}
-	resumeFile := path.Join(s.DataDir, resumeFileName)
-	log.Infof("Download resume file: %s, it will be auto delete when download finished.", resumeFile)
+
+	resumeFile := s.resumeFile(tasks)
+	s.logger.Infof("Download resume file: %s, it will be auto delete when download finished.", resumeFile)
 
 	if !s.IsResume {
 		if _, err := os.Stat(resumeFile); !os.IsNotExist(err) {
-			log.Infof("Not enable resume, so remove resume file: %s", resumeFile)
+			s.logger.Infof("Not enable resume, so remove resume file: %s", resumeFile)
 			if err := os.Remove(resumeFile); err != nil {
-				return err
+				return nil, s.failAll(tasks, err)
 			}
 		}
 	}
 	cfg.Database = resumeFile
+	if s.Fetch.MaxSize > 0 {
+		cfg.MaxTorrentSize = uint(s.Fetch.MaxSize)
+	}
 
-	log.Debugf("Torrent new session with config %+v", cfg)
+	s.logger.Debugf("Torrent new session with config %+v", cfg)
 	ses, err := torrent.NewSession(cfg)
 	if err != nil {
-		return err
+		return nil, s.failAll(tasks, err)
 	}
+	defer s.cleanup(tasks)
 	defer ses.Close()
-	var t *torrent.Torrent
-	torrents := ses.ListTorrents()
-	if len(torrents) > 0 && torrents[0].InfoHash() == ih {
-		// Resume data exists
-		t = torrents[0]
-		err = t.Start()
-	} else {
-		// Add as new torrent
-		opt := &torrent.AddTorrentOptions{
-			StopAfterDownload: !enableSeeding,
+	ids := make(map[string]bool)
+	for _, tk := range tasks {
+		if tk.err != nil {
+			continue
+		}
+		if err := s.prepareDir(tk); err != nil {
+			s.logger.Errorf("Failed to prepare dir of %s: %s", tk.job.Target, err)
+			s.fail(tk, err)
+			if len(tasks) == 1 {
+				return nil, tk.err
+			}
+			continue
 		}
-		if isURI(s.Target) {
-			t, err = ses.AddURI(s.Target, opt)
+		id := torrentID(tk.dir, ids)
+		if t := ses.GetTorrent(id); t != nil && t.InfoHash() == tk.target.infoHash {
+			// Resume data exists
+			tk.torrent = t
+			tk.err = t.Start()
 		} else {
-			var f *os.File
-			f, err = os.Open(s.Target)
-			if err != nil {
-				return err
+			// Add as new torrent。不做种时由 check 在 tracker 收到 completed 事件后停止。
+			// 不使用 StopAfterDownload：它会在 completed 事件发出前停止 torrent，而 rain 停止时关闭了数据，
+			// stopped 事件的 left 为整个 torrent 的大小，tracker 无法记录下载完成
+			opt := &torrent.AddTorrentOptions{
+				ID: id,
+			}
+			tk.torrent, tk.err = tk.target.add(ses, opt)
+		}
+		if tk.err != nil {
+			s.logger.Errorf("Failed to add %s: %s", tk.job.Target, tk.err)
+			s.fail(tk, tk.err)
+			if len(tasks) == 1 {
+				return nil, tk.err
 			}
-			t, err = ses.AddTorrent(f, opt)
-			f.Close()
 		}
 	}
-	if err != nil {
-		return err
+
+	err = classifyError(s.wait(ctx, runCtx.Done(), tasks))
+	// 下载失败时只有开启了 resume 才保留 resume 文件
+	if err != nil && s.IsResume {
+		return nil, err
+	}
+	if err == nil {
+		s.logger.Infof("Torrent stopped normally, so remove resume file")
+	}
+	if _, err := os.Stat(resumeFile); !os.IsNotExist(err) {
+		os.Remove(resumeFile)
+	}
+	return nil, err
+}
+
+// wait 等待所有任务结束，期间输出状态并控制做种的停止。
+// ctx 结束时停止所有任务，timeout 关闭时（超过 Timeout）停止未完成的任务
+func (s *TorrentServer) wait(ctx context.Context, timeout <-chan struct{}, tasks []*task) error {
+	type stopEvent struct {
+		task *task
+		err  error
+	}
+	stopped := make(chan stopEvent, len(tasks))
+	running := 0
+	for _, tk := range tasks {
+		if tk.torrent == nil {
+			continue
+		}
+		running++
+		go func(tk *task, ch <-chan error) {
+			stopped <- stopEvent{task: tk, err: <-ch}
+		}(tk, tk.torrent.NotifyStop())
 	}
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-	for {
+
+	cancel := ctx.Done()
+	for running > 0 {
 		select {
-		case s := <-ch:
-			log.Infof("received %s, stopping server", s)
-			err = t.Stop()
-			if err != nil {
-				return err
+		case <-timeout:
+			// Done 关闭后一直可读，只处理一次
+			timeout = nil
+			if ctx.Err() != nil {
+				// ctx 结束时 timeout 也会关闭，由 cancel 处理
+				continue
 			}
-		case <-time.After(time.Second):
-			stats := t.Stats()
-			progress := 0
-			if stats.Bytes.Total > 0 {
-				progress = int((stats.Bytes.Completed * 100) / stats.Bytes.Total)
-			}
-			eta := "?"
-			if stats.ETA != nil {
-				eta = stats.ETA.String()
+			s.logger.Errorf("Download timeout %s is reached, stopping incomplete torrents", s.Timeout)
+			for _, tk := range tasks {
+				if tk.torrent != nil && !tk.done && !tk.complete {
+					tk.abort(s.stopReason(ctx))
+					s.stopTorrent(tk)
+				}
 			}
-			log.Infof(
-				"Status: %s, Progress: %d%%, Peers: %d(%din/%dout), Download: %dK/s, Upload: %dK/s, ETA: %s, Seeding: %s\n",
-				stats.Status.String(), progress, stats.Peers.Total, stats.Peers.Incoming, stats.Peers.Outgoing,
-				stats.Speed.Download/1024, stats.Speed.Upload/1024, eta, stats.SeededFor.Truncate(time.Second).String(),
-			)
-			// 如果 maxSeedingSeconds 大于 0，则控制做种不能超过此值，对 isServe = true/false 均有效
-			if s.MaxSeedingSeconds > 0 && stats.Status == torrent.Seeding && stats.SeededFor.Seconds() > float64(s.MaxSeedingSeconds) {
-				log.Infof("Seeding max time %d is reached, stop seeding.", s.MaxSeedingSeconds)
-				err = t.Stop()
-				if err != nil {
-					log.Errorf("Stop seeding error: %s", err)
-					return err
+		case <-cancel:
+			cancel = nil
+			reason := s.stopReason(ctx)
+			s.logger.Infof("Stopping server: %s", ctx.Err())
+			for _, tk := range tasks {
+				if tk.torrent != nil && !tk.done {
+					tk.abort(reason)
+					s.stopTorrent(tk)
 				}
 			}
-			// 如果开启了 seedingAutoStop，那么就检查 Tracker 中是否还有未完成的节点，如果有则停止
-			// Tracker 默认的最小检查周期是 1min，所以此处存在一定的延迟，所以除非文件很大或单节点的带宽较小，否则不建议开启下载后做种功能
-			if s.SeedingAutoStop && stats.Status == torrent.Seeding {
-				willStop := true
-				for _, tracker := range t.Trackers() {
-					log.Debugf(
-						"tracker: %s status:%d leechers:%d seeders: %d LastAnnounce:%s",
-						tracker.URL, tracker.Status, tracker.Leechers, tracker.Seeders, tracker.LastAnnounce.String())
-					if tracker.Leechers > 0 {
-						willStop = false
-						break
-					}
+		case <-time.After(time.Second):
+			for _, tk := range tasks {
+				if tk.torrent == nil || tk.done {
+					continue
 				}
-				if willStop {
-					log.Infof("All tracker has no leechers, stop seeding.")
-					err = t.Stop()
-					if err != nil {
-						log.Errorf("Stop seeding error: %s", err)
-						return err
+				if tk.stopped {
+					// 等待解压结束
+					if s.onStop(tk, tk.stopErr) {
+						running--
 					}
+					continue
+				}
+				if tk.abortErr != nil || tk.failErr != nil {
+					// 停止失败时重试
+					s.stopTorrent(tk)
+					continue
+				}
+				if err := s.check(tk, len(tasks) > 1); err != nil {
+					// 只结束出错的任务，其他任务继续
+					s.failTask(tk, err)
 				}
 			}
-		case err = <-t.NotifyStop():
-			if err != nil {
-				log.Warnf("Torrent stopped: %s", err)
+		case ev := <-stopped:
+			ev.task.stopped = true
+			ev.task.stopErr = ev.err
+			if s.onStop(ev.task, ev.err) {
+				running--
+			}
+		}
+	}
+	// OnComplete 执行失败时任务也视为失败
+	s.waitHooks(tasks)
+
+	if len(tasks) == 1 {
+		return tasks[0].err
+	}
+	failed := 0
+	for _, tk := range tasks {
+		if tk.err != nil {
+			failed++
+			s.logger.Errorf("Download failed: %s (dir: %s): %s", tk.job.Target, tk.job.DataDir, tk.err)
+		} else {
+			s.logger.Infof("Download finished: %s (dir: %s)", tk.job.Target, tk.job.DataDir)
+		}
+	}
+	if failed > 0 {
+		// 包装第一个失败的原因，以便调用方通过 errors.Is 判断
+		for _, tk := range tasks {
+			if tk.err != nil {
+				return fmt.Errorf("%d of %d downloads failed: %w", failed, len(tasks), tk.err)
+			}
+		}
+	}
+	return nil
+}
+
+// completedAnnounceTimeout 是下载完成后等待 tracker 收到 completed 事件的最长时间，和 rain 发送 stopped 事件的超时相同
+const completedAnnounceTimeout = 5 * time.Second
+
+func (s *TorrentServer) seeding() bool {
+	return s.IsServe || s.MaxSeedingSeconds > 0
+}
+
+// lastAnnounce 返回所有 tracker 中最近一次成功 announce 的时间
+func lastAnnounce(t *torrent.Torrent) time.Time {
+	var last time.Time
+	for _, tr := range t.Trackers() {
+		if tr.LastAnnounce.After(last) {
+			last = tr.LastAnnounce
+		}
+	}
+	return last
+}
+
+// prepareDir 设置 torrent 的存储路径：做种时为 DataDir，其他情况为 staging 目录
+func (s *TorrentServer) prepareDir(tk *task) error {
+	switch {
+	case s.IsServe:
+		tk.dir = tk.job.DataDir
+	default:
+		tk.dir = stagingDir(tk.job.DataDir, tk.target.infoHash)
+		tk.staged = true
+		if !s.IsResume {
+			// 上次失败时遗留的数据
+			if err := os.RemoveAll(tk.dir); err != nil {
 				return err
 			}
-			// TODO: 只有下载完成才应该删除 resumeFile
-			log.Infof("Torrent stopped normally, so remove resume file")
-			if _, err := os.Stat(resumeFile); !os.IsNotExist(err) {
-				os.Remove(resumeFile)
+		}
+	}
+	return nil
+}
+
+// onStop 处理任务停止事件，torrent 正常停止时检查数据是否已经下载完成。
+// 解压还没有结束时返回 false，wait 在之后的检查中再次调用
+func (s *TorrentServer) onStop(tk *task, err error) bool {
+	tk.updateStats(tk.torrent.Stats())
+	if err == nil {
+		err = tk.failErr
+	}
+	if err == nil {
+		err = s.checkComplete(tk)
+	} else {
+		tk.pollExtract()
+	}
+	if tk.extractResult != nil {
+		return false
+	}
+	tk.done = true
+	if err == nil && !tk.complete && tk.abortErr != nil {
+		err = tk.abortErr
+	}
+	err = classifyError(err)
+	if err == nil && !tk.complete && !s.IsServe {
+		err = fmt.Errorf("torrent %s stopped before download completed", tk.target.name)
+	}
+	tk.err = err
+	if err != nil {
+		s.logger.Warnf("Torrent %s stopped: %s", tk.target.name, err)
+	} else {
+		s.logger.Infof("Torrent %s stopped normally", tk.target.name)
+	}
+	s.runHook(tk)
+	return true
+}
+
+// stopReason 返回任务因 ctx 结束或超过 Timeout 被停止的原因
+func (s *TorrentServer) stopReason(ctx context.Context) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %s", ErrTimeout, ctx.Err())
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: %s", ErrInterrupted, ctx.Err())
+	default:
+		return fmt.Errorf("%w: not completed in %s", ErrTimeout, s.Timeout)
+	}
+}
+
+// failTask 以 err 结束运行中的任务并停止 torrent，onStop 中使用该原因，不影响其他任务
+func (s *TorrentServer) failTask(tk *task, err error) {
+	s.logger.Errorf("Torrent %s failed: %s", tk.target.name, err)
+	tk.failErr = err
+	s.stopTorrent(tk)
+}
+
+// stopTorrent 停止任务的 torrent，停止失败时由 wait 在下次检查时重试
+func (s *TorrentServer) stopTorrent(tk *task) {
+	if status := tk.torrent.Stats().Status; status == torrent.Stopping || status == torrent.Stopped {
+		return
+	}
+	if err := tk.torrent.Stop(); err != nil {
+		s.logger.Errorf("Failed to stop torrent %s: %s", tk.target.name, err)
+	}
+}
+
+// fail 记录任务启动失败的原因并执行 OnError
+func (s *TorrentServer) fail(tk *task, err error) {
+	tk.done = true
+	tk.err = classifyError(err)
+	s.runHook(tk)
+}
+
+// failAll 以 err 结束所有还没有结束的任务，用于 session 等所有任务共享的资源出错时
+func (s *TorrentServer) failAll(tasks []*task, err error) error {
+	for _, tk := range tasks {
+		if !tk.done {
+			s.fail(tk, err)
+		}
+	}
+	return classifyError(err)
+}
+
+// check 输出任务状态，并根据做种配置决定是否停止任务
+func (s *TorrentServer) check(tk *task, withName bool) error {
+	t := tk.torrent
+	stats := t.Stats()
+	tk.updateStats(stats)
+	if !tk.complete {
+		tk.announcedAt = lastAnnounce(t)
+	}
+	progress := 0
+	if stats.Bytes.Total > 0 {
+		progress = int((stats.Bytes.Completed * 100) / stats.Bytes.Total)
+	}
+	eta := "?"
+	if stats.ETA != nil {
+		eta = stats.ETA.String()
+	}
+	prefix := ""
+	if withName {
+		prefix = fmt.Sprintf("[%s] ", tk.target.name)
+	}
+	s.logger.Infof(
+		"%sStatus: %s, Progress: %d%%, Peers: %d(%din/%dout), Download: %dK/s, Upload: %dK/s, ETA: %s, Seeding: %s\n",
+		prefix, stats.Status.String(), progress, stats.Peers.Total, stats.Peers.Incoming, stats.Peers.Outgoing,
+		stats.Speed.Download/1024, stats.Speed.Upload/1024, eta, stats.SeededFor.Truncate(time.Second).String(),
+	)
+	if err := s.checkComplete(tk); err != nil {
+		s.logger.Errorf("Finalize download error: %s", err)
+		return err
+	}
+	s.progress(tk)
+	// 做种的文件和 torrent 不一致时，校验后 rain 会开始下载缺少的 piece，而做种节点没有其他 peer 可以下载
+	if s.IsServe && stats.Status == torrent.Downloading {
+		tk.abort(fmt.Errorf("%w: data of %s in %s doesn't match torrent", ErrVerification, tk.target.name, tk.dir))
+		s.stopTorrent(tk)
+		return nil
+	}
+	if s.StallTimeout > 0 && !tk.complete && tk.checkStall(s.StallTimeout, stats.Bytes.Downloaded) {
+		s.stopTorrent(tk)
+		return nil
+	}
+	// 不做种时，等待下载完成后的 announce（completed 事件）成功后停止，以便 tracker 记录下载完成。
+	// 没有 tracker 或所有 tracker 都无法访问时，和 StopAfterDownload 一样立即停止
+	if !s.seeding() && tk.complete && stats.Status != torrent.Stopping && stats.Status != torrent.Stopped &&
+		(trackerUnreachable(t) || len(t.Trackers()) == 0 || lastAnnounce(t).After(tk.announcedAt) ||
+			time.Since(tk.completedAt) > completedAnnounceTimeout) {
+		s.logger.Infof("Download of %s is completed, stop torrent.", tk.target.name)
+		// 已经停止，不再执行下面的做种检查，避免重复 Stop
+		return t.Stop()
+	}
+	// 如果 maxSeedingSeconds 大于 0，则控制做种不能超过此值，对 isServe = true/false 均有效
+	if s.MaxSeedingSeconds > 0 && stats.Status == torrent.Seeding && stats.SeededFor.Seconds() > float64(s.MaxSeedingSeconds) {
+		s.logger.Infof("Seeding max time %d is reached, stop seeding.", s.MaxSeedingSeconds)
+		err := t.Stop()
+		if err != nil {
+			s.logger.Errorf("Stop seeding error: %s", err)
+		}
+		return err
+	}
+	// 如果开启了 seedingAutoStop，那么就检查 Tracker 中是否还有未完成的节点，如果有则停止
+	// leecher 数量在 announce 时更新，周期为 Tracker 返回的 interval（内置 Tracker 根据 swarm 大小为 15s 起），
+	// 所以此处存在一定的延迟，所以除非文件很大或单节点的带宽较小，否则不建议开启下载后做种功能
+	if s.SeedingAutoStop && stats.Status == torrent.Seeding {
+		willStop := true
+		for _, tracker := range t.Trackers() {
+			s.logger.Debugf(
+				"tracker: %s status:%d leechers:%d seeders: %d LastAnnounce:%s",
+				tracker.URL, tracker.Status, tracker.Leechers, tracker.Seeders, tracker.LastAnnounce.String())
+			if tracker.Leechers > 0 {
+				willStop = false
+				break
+			}
+		}
+		if willStop {
+			s.logger.Infof("All tracker has no leechers, stop seeding.")
+			err := t.Stop()
+			if err != nil {
+				s.logger.Errorf("Stop seeding error: %s", err)
+				return err
 			}
-			return nil
 		}
 	}
+	return nil
 }
 
 // @param files: include this file or directory in torrent
@@ -216,16 +531,27 @@ func (s *TorrentServer) Run() error {
 // @param trackers: add tracker `URL`
 // @param webseeds: add web seed `URL`
 func CreateTorrent(files []string, out string, root string, name string, private bool, pieceLength int, comment string, trackers []string, webseeds []string) (string, error) {
+	return CreateTorrentWithTrackers(files, out, root, name, private, pieceLength, comment, func(string) []string { return trackers }, webseeds)
+}
+
+// CreateTorrentWithTrackers is like CreateTorrent, but tracker urls are generated from the hex encoded info hash,
+// e.g. to sign them with libtracker.Token.
+func CreateTorrentWithTrackers(files []string, out string, root string, name string, private bool, pieceLength int, comment string, trackersOf func(infoHash string) []string, webseeds []string) (string, error) {
 	var err error
+	info, err := metainfo.NewInfoBytes(root, files, private, uint32(pieceLength<<10), name)
+	if err != nil {
+		return "", err
+	}
+	i, err := metainfo.NewInfo(info)
+	if err != nil {
+		return "", err
+	}
+	trackers := trackersOf(string(i.HashString()))
 	tiers := make([][]string, len(trackers))
 	for i, tr := range trackers {
 		tiers[i] = []string{tr}
 	}
 
-	info, err := metainfo.NewInfoBytes(root, files, private, uint32(pieceLength<<10), name)
-	if err != nil {
-		return "", err
-	}
 	mi, err := metainfo.NewBytes(info, tiers, webseeds, comment)
 	if err != nil {
 		return "", err
@@ -240,11 +566,6 @@ func CreateTorrent(files []string, out string, root string, name string, private
 		return "", err
 	}
 
-	i, err := metainfo.NewInfo(info)
-	if err != nil {
-		return "", err
-	}
-
 	magnet := fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s", string(i.HashString()), i.Name)
 	trackersEscaped := make([]string, len(trackers))
 	for _, s := range trackers {
@@ -0,0 +1,151 @@
+package libtorrent
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// Progress 是一个任务的下载进度
+type Progress struct {
+	// 任务的 magnet uri、torrent url 或 torrent 文件
+	Target string
+	// torrent 名称
+	Name   string
+	Status string
+	// 字节数，Total 在获取到 metadata 前为 0
+	BytesTotal      int64
+	BytesCompleted  int64
+	BytesDownloaded int64
+	BytesUploaded   int64
+	// 字节每秒
+	DownloadSpeed int
+	UploadSpeed   int
+	Peers         int
+	// 为 0 表示未知
+	ETA       time.Duration
+	SeededFor time.Duration
+	// 需要的数据已经下载完成
+	Complete bool
+}
+
+// Result 是 RunContext 的结果
+type Result struct {
+	Tasks    []TaskResult
+	Duration time.Duration
+}
+
+// TaskResult 是一个任务的结果
+type TaskResult struct {
+	Target  string
+	DataDir string
+	Name    string
+	// 为空表示 target 解析失败
+	InfoHash string
+	// 下载完成的文件或目录，下载失败时为空
+	Path string
+	// torrent 的大小及从其他节点下载、上传的字节数
+	Size       int64
+	Downloaded int64
+	Uploaded   int64
+	// 同时连接的最大 peer 数
+	Peers int
+	// 从任务开始到下载完成的时间，下载失败时为到任务结束的时间
+	Duration time.Duration
+	Err      error
+}
+
+// Downloaded returns the total bytes downloaded from peers by all tasks.
+func (r *Result) Downloaded() int64 {
+	var n int64
+	for _, t := range r.Tasks {
+		n += t.Downloaded
+	}
+	return n
+}
+
+// Failed returns the tasks that failed.
+func (r *Result) Failed() []TaskResult {
+	var failed []TaskResult
+	for _, t := range r.Tasks {
+		if t.Err != nil {
+			failed = append(failed, t)
+		}
+	}
+	return failed
+}
+
+// updateStats 记录最近的状态，torrent 停止后仍然可以生成 Result
+func (tk *task) updateStats(stats torrent.Stats) {
+	tk.stats = stats
+	if stats.Peers.Total > tk.maxPeers {
+		tk.maxPeers = stats.Peers.Total
+	}
+}
+
+// path 返回下载完成的文件或目录，未下载完成时为空
+func (tk *task) path() string {
+	if !tk.finalized && (!tk.complete || tk.staged) {
+		return ""
+	}
+	info, err := tk.info()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(tk.job.DataDir, rootName(info))
+}
+
+func (s *TorrentServer) progress(tk *task) {
+	if s.Progress == nil {
+		return
+	}
+	stats := tk.stats
+	p := Progress{
+		Target:          tk.job.Target,
+		Name:            tk.target.name,
+		Status:          stats.Status.String(),
+		BytesTotal:      stats.Bytes.Total,
+		BytesCompleted:  stats.Bytes.Completed,
+		BytesDownloaded: stats.Bytes.Downloaded,
+		BytesUploaded:   stats.Bytes.Uploaded,
+		DownloadSpeed:   stats.Speed.Download,
+		UploadSpeed:     stats.Speed.Upload,
+		Peers:           stats.Peers.Total,
+		SeededFor:       stats.SeededFor,
+		Complete:        tk.complete,
+	}
+	if stats.ETA != nil {
+		p.ETA = *stats.ETA
+	}
+	s.Progress(p)
+}
+
+func (s *TorrentServer) result(tasks []*task, duration time.Duration) *Result {
+	res := &Result{Tasks: make([]TaskResult, len(tasks)), Duration: duration}
+	for i, tk := range tasks {
+		end := tk.completedAt
+		if end.IsZero() {
+			end = time.Now()
+		}
+		r := TaskResult{
+			Target:     tk.job.Target,
+			DataDir:    tk.job.DataDir,
+			Path:       tk.path(),
+			Downloaded: tk.stats.Bytes.Downloaded,
+			Uploaded:   tk.stats.Bytes.Uploaded,
+			Peers:      tk.maxPeers,
+			Duration:   end.Sub(tk.startedAt),
+			Err:        tk.err,
+		}
+		if tk.target != nil {
+			r.Name = tk.target.name
+			r.InfoHash = tk.target.infoHash.String()
+		}
+		if info, err := tk.info(); err == nil {
+			r.Size = info.Length
+		}
+		res.Tasks[i] = r
+	}
+	return res
+}
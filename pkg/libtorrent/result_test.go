@@ -0,0 +1,48 @@
+package libtorrent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunContextResult(t *testing.T) {
+	s := &TorrentServer{Jobs: []DownloadJob{
+		{Target: "magnet:?xt=urn:btih:zz", DataDir: t.TempDir()},
+		{Target: "missing.torrent", DataDir: t.TempDir()},
+	}, DataDir: t.TempDir()}
+	res, err := s.RunContext(context.Background())
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+	if assert.NotNil(t, res) {
+		assert.Len(t, res.Tasks, 2)
+		assert.Len(t, res.Failed(), 2)
+		assert.ErrorIs(t, res.Tasks[0].Err, ErrInvalidTarget)
+		assert.Equal(t, "missing.torrent", res.Tasks[1].Target)
+	}
+}
+
+func TestRunContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var progress []Progress
+	s := &TorrentServer{
+		Target:   "magnet:?xt=urn:btih:b4f39c153e9cf1248bc96355f1249fdc2b020eaa",
+		DataDir:  t.TempDir(),
+		Progress: func(p Progress) { progress = append(progress, p) },
+	}
+	res, err := s.RunContext(ctx)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.NotEmpty(t, progress)
+	if assert.NotNil(t, res) && assert.Len(t, res.Tasks, 1) {
+		assert.Equal(t, "b4f39c153e9cf1248bc96355f1249fdc2b020eaa", res.Tasks[0].InfoHash)
+		assert.Empty(t, res.Tasks[0].Path)
+	}
+
+	s.Target = "magnet:?xt=urn:btih:b4f39c153e9cf1248bc96355f1249fdc2b020eab"
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(1500*time.Millisecond, cancel)
+	_, err = s.RunContext(ctx)
+	assert.ErrorIs(t, err, ErrInterrupted)
+}
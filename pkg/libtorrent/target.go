@@ -0,0 +1,84 @@
+package libtorrent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cenkalti/rain/torrent"
+	"github.com/ninehills/p2pfile/pkg/magnet"
+	"github.com/ninehills/p2pfile/pkg/metainfo"
+)
+
+// target 是解析后的下载目标，magnet 和 torrent 只有一个非空
+type target struct {
+	infoHash torrent.InfoHash
+	name     string
+	// magnet uri
+	magnet string
+	// .torrent 文件内容，来自本地文件或 HTTP(S) 下载
+	torrent []byte
+}
+
+// resolveTarget 解析 magnet uri、HTTP(S) 上的 .torrent 文件或本地 .torrent 文件，ctx 结束时停止获取 .torrent 文件
+func resolveTarget(ctx context.Context, arg string, opt FetchOptions) (*target, error) {
+	if isMagnet(arg) {
+		m, err := magnet.New(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTarget, err)
+		}
+		return &target{
+			infoHash: torrent.InfoHash(m.InfoHash),
+			name:     m.Name,
+			magnet:   arg,
+		}, nil
+	}
+
+	var b []byte
+	var err error
+	if isHTTPURL(arg) {
+		b, err = FetchTorrent(ctx, arg, opt)
+	} else {
+		b, err = readTorrentFile(arg, opt.MaxSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	mi, err := metainfo.New(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%w: torrent %s: %s", ErrInvalidTarget, arg, err)
+	}
+	return &target{
+		infoHash: torrent.InfoHash(mi.Info.Hash),
+		name:     mi.Info.Name,
+		torrent:  b,
+	}, nil
+}
+
+func (t *target) add(ses *torrent.Session, opt *torrent.AddTorrentOptions) (*torrent.Torrent, error) {
+	if t.magnet != "" {
+		return ses.AddURI(t.magnet, opt)
+	}
+	return ses.AddTorrent(bytes.NewReader(t.torrent), opt)
+}
+
+func readTorrentFile(name string, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxTorrentSize
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxSize {
+		return nil, fmt.Errorf("%w: torrent file %s is too large: more than %d bytes", ErrInvalidTarget, name, maxSize)
+	}
+	return b, nil
+}
@@ -0,0 +1,39 @@
+package libtorrent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// abort 记录停止未完成的任务的原因，onStop 中使用该原因，torrent 由调用方停止。
+// 超时或停滞时如果所有 tracker 都无法访问，失败原因为 ErrTrackerUnreachable
+func (tk *task) abort(err error) {
+	if tk.abortErr != nil {
+		return
+	}
+	if (errors.Is(err, ErrTimeout) || errors.Is(err, ErrStalled) || errors.Is(err, ErrMetadataTimeout)) && trackerUnreachable(tk.torrent) {
+		err = fmt.Errorf("%w: %s", ErrTrackerUnreachable, err)
+	}
+	tk.abortErr = err
+}
+
+// checkStall 返回任务是否长时间没有进展，是则记录失败原因，torrent 由调用方停止。
+// 已经下载完成的任务（如做种）不受影响
+func (tk *task) checkStall(stallTimeout time.Duration, downloaded int64) bool {
+	now := time.Now()
+	if tk.progressAt.IsZero() || downloaded > tk.downloaded {
+		tk.progressAt = now
+		tk.downloaded = downloaded
+		return false
+	}
+	if tk.complete || now.Sub(tk.progressAt) < stallTimeout {
+		return false
+	}
+	if _, err := tk.info(); err != nil {
+		tk.abort(fmt.Errorf("%w: no metadata received in %s", ErrMetadataTimeout, stallTimeout))
+	} else {
+		tk.abort(fmt.Errorf("%w: no data received in %s", ErrStalled, stallTimeout))
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package libtorrent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSession(t *testing.T) *torrent.Session {
+	cfg := torrent.DefaultConfig
+	cfg.RPCEnabled = false
+	cfg.DHTEnabled = false
+	cfg.DataDir = t.TempDir()
+	cfg.Database = filepath.Join(t.TempDir(), "session.db")
+	ses, err := torrent.NewSession(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { ses.Close() })
+	return ses
+}
+
+// addTestTorrent 将 torrent 添加到 session，trackers 为空时 torrent 没有 tracker
+func addTestTorrent(t *testing.T, ses *torrent.Session, trackers []string) *task {
+	tk := newTestTask(t)
+	out := filepath.Join(t.TempDir(), "data.torrent")
+	_, err := CreateTorrent([]string{filepath.Join(tk.job.DataDir, "data.bin")}, out, tk.job.DataDir, "", false, 0, "", trackers, nil)
+	require.NoError(t, err)
+	tk.target, err = resolveTarget(context.Background(), out, FetchOptions{})
+	require.NoError(t, err)
+	tk.torrent, err = tk.target.add(ses, nil)
+	require.NoError(t, err)
+	tk.complete, tk.finalized = false, false
+	return tk
+}
+
+func TestAbort(t *testing.T) {
+	ses := newTestSession(t)
+
+	tk := addTestTorrent(t, ses, nil)
+	tk.abort(ErrVerification)
+	// 只记录第一次的原因
+	tk.abort(ErrInterrupted)
+	assert.Equal(t, ErrVerification, tk.abortErr)
+
+	// 没有 tracker 时不视为 tracker 无法访问
+	tk = addTestTorrent(t, ses, nil)
+	tk.abort(ErrTimeout)
+	assert.Equal(t, ErrTimeout, tk.abortErr)
+
+	tk = addTestTorrent(t, ses, []string{"http://127.0.0.1:1/announce"})
+	require.Eventually(t, func() bool { return trackerUnreachable(tk.torrent) }, 10*time.Second, 100*time.Millisecond)
+	tk.abort(ErrInterrupted)
+	assert.Equal(t, ErrInterrupted, tk.abortErr)
+	for _, err := range []error{ErrTimeout, ErrStalled, ErrMetadataTimeout} {
+		tk.abortErr = nil
+		tk.abort(err)
+		assert.ErrorIs(t, tk.abortErr, ErrTrackerUnreachable)
+	}
+}
+
+func TestCheckStall(t *testing.T) {
+	ses := newTestSession(t)
+	tk := addTestTorrent(t, ses, nil)
+
+	assert.False(t, tk.checkStall(time.Minute, 0))
+	assert.False(t, tk.checkStall(time.Minute, 0))
+	tk.progressAt = time.Now().Add(-2 * time.Minute)
+	// 有新的数据时重新计时
+	assert.False(t, tk.checkStall(time.Minute, 10))
+	assert.Equal(t, int64(10), tk.downloaded)
+	assert.False(t, tk.checkStall(time.Minute, 10))
+
+	tk.progressAt = time.Now().Add(-2 * time.Minute)
+	tk.complete = true
+	assert.False(t, tk.checkStall(time.Minute, 10))
+	assert.Nil(t, tk.abortErr)
+	tk.complete = false
+	assert.True(t, tk.checkStall(time.Minute, 10))
+	assert.ErrorIs(t, tk.abortErr, ErrStalled)
+
+	// magnet 没有获取到 metadata
+	m := &task{job: DownloadJob{Target: "magnet:?xt=urn:btih:b4f39c153e9cf1248bc96355f1249fdc2b020eaa"}}
+	var err error
+	m.target, err = resolveTarget(context.Background(), m.job.Target, FetchOptions{})
+	require.NoError(t, err)
+	m.torrent, err = m.target.add(ses, nil)
+	require.NoError(t, err)
+	assert.False(t, m.checkStall(time.Minute, 0))
+	m.progressAt = time.Now().Add(-2 * time.Minute)
+	assert.True(t, m.checkStall(time.Minute, 0))
+	assert.ErrorIs(t, m.abortErr, ErrMetadataTimeout)
+}
+
+func TestRunContextTimeout(t *testing.T) {
+	s := &TorrentServer{
+		Target:  "magnet:?xt=urn:btih:b4f39c153e9cf1248bc96355f1249fdc2b020eac",
+		DataDir: t.TempDir(),
+		Timeout: time.Second,
+	}
+	start := time.Now()
+	_, err := s.RunContext(context.Background())
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Less(t, time.Since(start), 3*time.Second)
+
+	// 获取 torrent 文件之前已经超时
+	s.Timeout = time.Nanosecond
+	_, err = s.RunContext(context.Background())
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Timeout = time.Minute
+	_, err = s.RunContext(ctx)
+	assert.ErrorIs(t, err, ErrInterrupted)
+}
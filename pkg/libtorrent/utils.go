@@ -39,9 +39,9 @@ func GetAvailablePort(portRange string) (int, error) {
 	return 0, fmt.Errorf("no available ports in range: %v", portRange)
 }
 
+// isPortAvailable 检查端口是否可以监听，"tcp" 和 "udp" 在支持 IPv6 时监听双栈，否则只监听 IPv4
 func isPortAvailable(port int) bool {
-	// if use ipv6, need check udp6 and tcp6
-	udpLn, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", port))
+	udpLn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		log.Debugf("UDP Port %v is not available: %v", port, err)
 		return false
@@ -52,7 +52,7 @@ func isPortAvailable(port int) bool {
 		return false
 	}
 
-	tcpLn, err := net.Listen("tcp4", fmt.Sprintf(":%d", port))
+	tcpLn, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		log.Debugf("TCP Port %v is not available: %v", port, err)
 		return false
@@ -65,22 +65,28 @@ func isPortAvailable(port int) bool {
 	return true
 }
 
+// GetPublicIP parses ip, or chooses the ip of default route interface if ip is empty.
+// IPv4 is preferred, IPv6 is chosen on IPv6-only hosts.
 func GetPublicIP(ip string) (net.IP, error) {
-	var err error
-	var publicIP net.IP
 	if ip != "" {
-		publicIP = net.ParseIP(ip)
-	} else {
-		publicIP, err = apimachinery_net.ChooseHostInterface()
-		if err != nil {
-			log.Fatalf("failed to get default public ip: %v", err)
-		} else {
-			log.Infof("get default public ip: %s", publicIP)
+		publicIP := net.ParseIP(ip)
+		if publicIP == nil {
+			return nil, fmt.Errorf("invalid ip: %s", ip)
 		}
+		return publicIP, nil
 	}
+	publicIP, err := apimachinery_net.ChooseHostInterface()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default public ip: %w", err)
+	}
+	log.Infof("get default public ip: %s", publicIP)
 	return publicIP, nil
 }
 
-func isURI(arg string) bool {
-	return strings.HasPrefix(arg, "magnet:") || strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")
+func isMagnet(arg string) bool {
+	return strings.HasPrefix(arg, "magnet:")
+}
+
+func isHTTPURL(arg string) bool {
+	return strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")
 }
@@ -0,0 +1,250 @@
+package libtracker
+
+import (
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// AdminPeer is a peer in admin API.
+type AdminPeer struct {
+	IP   string `json:"ip"`
+	Port uint16 `json:"port"`
+	// hex 编码的 peer_id
+	PeerID   string    `json:"peer_id"`
+	Zone     string    `json:"zone,omitempty"`
+	Seeding  bool      `json:"seeding"`
+	LastSeen time.Time `json:"last_seen"`
+	Expires  time.Time `json:"expires"`
+}
+
+// AdminSwarm is a swarm in admin API, Peers is only set for a single swarm.
+type AdminSwarm struct {
+	Room string `json:"room"`
+	// hex 编码的 info hash
+	InfoHash   string      `json:"info_hash"`
+	Seeders    int         `json:"seeders"`
+	Leechers   int         `json:"leechers"`
+	Downloaded int         `json:"downloaded"`
+	Peers      []AdminPeer `json:"peers,omitempty"`
+}
+
+// AdminRoom is a room in admin API.
+type AdminRoom struct {
+	Room     string `json:"room"`
+	Swarms   int    `json:"swarms"`
+	Seeders  int    `json:"seeders"`
+	Leechers int    `json:"leechers"`
+}
+
+func (sw swarm) admin(withPeers bool) AdminSwarm {
+	s := AdminSwarm{
+		Room:       sw.room,
+		InfoHash:   hex.EncodeToString([]byte(sw.infoHash)),
+		Seeders:    len(sw.seeders),
+		Leechers:   len(sw.leechers),
+		Downloaded: countCompleted(sw.history),
+	}
+	if !withPeers {
+		return s
+	}
+	s.Peers = []AdminPeer{}
+	for _, seeding := range []bool{true, false} {
+		peers := sw.leechers
+		if seeding {
+			peers = sw.seeders
+		}
+		for addr, p := range peers {
+			pe := addr.deserialize(p)
+			s.Peers = append(s.Peers, AdminPeer{
+				IP:       pe.IP.String(),
+				Port:     pe.Port,
+				PeerID:   hex.EncodeToString([]byte(p.peerID)),
+				Zone:     p.zone,
+				Seeding:  seeding,
+				LastSeen: time.Unix(p.lastSeen, 0),
+				Expires:  time.Unix(p.expires, 0),
+			})
+		}
+	}
+	sort.Slice(s.Peers, func(i, j int) bool { return s.Peers[i].LastSeen.After(s.Peers[j].LastSeen) })
+	return s
+}
+
+// ListSwarms returns swarms in room, or all swarms if room is empty, sorted by room and info hash.
+func ListSwarms(room string) []AdminSwarm {
+	swarms := []AdminSwarm{}
+	for _, shard := range shards {
+		shard.RLock()
+		for _, sw := range shard.swarms {
+			if room == "" || sw.room == room {
+				swarms = append(swarms, sw.admin(false))
+			}
+		}
+		shard.RUnlock()
+	}
+	sort.Slice(swarms, func(i, j int) bool {
+		if swarms[i].Room != swarms[j].Room {
+			return swarms[i].Room < swarms[j].Room
+		}
+		return swarms[i].InfoHash < swarms[j].InfoHash
+	})
+	return swarms
+}
+
+// GetSwarm returns the swarm of torrent infoHash (raw 20 bytes) in room with its peers.
+func GetSwarm(room, infoHash string) (AdminSwarm, bool) {
+	h := sha1.Sum([]byte(room + infoHash))
+	shard := shards[shardIndex(h)]
+	shard.RLock()
+	defer shard.RUnlock()
+	sw, ok := shard.swarms[h]
+	if !ok {
+		return AdminSwarm{}, false
+	}
+	return sw.admin(true), true
+}
+
+// DeleteSwarm removes the swarm of torrent infoHash in room, including its peers and download history.
+func DeleteSwarm(room, infoHash string) bool {
+	h := sha1.Sum([]byte(room + infoHash))
+	shard := shards[shardIndex(h)]
+	shard.Lock()
+	defer shard.Unlock()
+	sw, ok := shard.swarms[h]
+	if !ok {
+		return false
+	}
+	delete(shard.swarms, h)
+	release(room, 1, len(sw.seeders)+len(sw.leechers), len(sw.history))
+	return true
+}
+
+// EvictPeer removes a peer from the swarm, the peer is added back on its next announce.
+func EvictPeer(room, infoHash, ip string, port uint16) bool {
+	h := sha1.Sum([]byte(room + infoHash))
+	shard := shards[shardIndex(h)]
+	shard.Lock()
+	defer shard.Unlock()
+	sw, ok := shard.swarms[h]
+	if !ok {
+		return false
+	}
+	return sw.remove(serialize(ip, port))
+}
+
+// adminInfoHash 解析路径中 hex 编码的 info hash，返回原始的 20 字节
+func adminInfoHash(c *gin.Context) (string, bool) {
+	ih, err := hex.DecodeString(c.Param("info_hash"))
+	if err != nil || len(ih) != 20 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "info_hash must be 40 hex characters"})
+		return "", false
+	}
+	return string(ih), true
+}
+
+func adminStats(c *gin.Context) {
+	rooms := make(map[string]bool)
+	swarms, peers := 0, 0
+	for _, sw := range ListSwarms("") {
+		rooms[sw.Room] = true
+		swarms++
+		peers += sw.Seeders + sw.Leechers
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"rooms":         len(rooms),
+		"swarms":        swarms,
+		"peers":         peers,
+		"announce_rate": currentAnnounceRate(),
+		"cleanup":       GetCleanupStats(),
+	})
+}
+
+func adminRooms(c *gin.Context) {
+	m := make(map[string]*AdminRoom)
+	var names []string
+	// ListSwarms 按 room 排序
+	for _, sw := range ListSwarms("") {
+		r, ok := m[sw.Room]
+		if !ok {
+			r = &AdminRoom{Room: sw.Room}
+			m[sw.Room] = r
+			names = append(names, sw.Room)
+		}
+		r.Swarms++
+		r.Seeders += sw.Seeders
+		r.Leechers += sw.Leechers
+	}
+	rooms := make([]AdminRoom, 0, len(names))
+	for _, name := range names {
+		rooms = append(rooms, *m[name])
+	}
+	c.JSON(http.StatusOK, rooms)
+}
+
+func adminSwarms(c *gin.Context) {
+	c.JSON(http.StatusOK, ListSwarms(c.Query("room")))
+}
+
+func adminSwarm(c *gin.Context) {
+	infoHash, ok := adminInfoHash(c)
+	if !ok {
+		return
+	}
+	sw, ok := GetSwarm(c.Param("room"), infoHash)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "swarm not found"})
+		return
+	}
+	c.JSON(http.StatusOK, sw)
+}
+
+func adminDeleteSwarm(c *gin.Context) {
+	infoHash, ok := adminInfoHash(c)
+	if !ok {
+		return
+	}
+	if !DeleteSwarm(c.Param("room"), infoHash) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "swarm not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// adminEvictPeer 删除 peer，addr 为 ip:port，IPv6 为 [ip]:port
+func adminEvictPeer(c *gin.Context) {
+	infoHash, ok := adminInfoHash(c)
+	if !ok {
+		return
+	}
+	host, portStr, err := net.SplitHostPort(c.Param("addr"))
+	port, perr := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || perr != nil || net.ParseIP(host) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "addr must be ip:port"})
+		return
+	}
+	if !EvictPeer(c.Param("room"), infoHash, host, uint16(port)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "peer not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// dashboard 返回 admin API 的网页，不需要 token，数据由网页通过 admin API 获取
+func dashboard(c *gin.Context) {
+	if getAdminToken() == "" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", dashboardHTML)
+}
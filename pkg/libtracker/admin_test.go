@@ -0,0 +1,69 @@
+package libtracker
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminSwarms(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	admin := r.Group("/admin", adminAuth)
+	admin.GET("/rooms", adminRooms)
+	admin.GET("/swarms", adminSwarms)
+	admin.GET("/swarms/:room/:info_hash", adminSwarm)
+	admin.DELETE("/swarms/:room/:info_hash", adminDeleteSwarm)
+	admin.DELETE("/swarms/:room/:info_hash/peers/:addr", adminEvictPeer)
+	r.GET("/admin/", dashboard)
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+
+	infoHash := strings.Repeat("i", 20)
+	PutPeer("admin-test", infoHash, strings.Repeat("p", 20), "rack1", "10.0.0.1", 6881, true)
+	PutPeer("admin-test", infoHash, "", "", "fd00::1", 6882, false)
+	path := "/admin/swarms/admin-test/" + hex.EncodeToString([]byte(infoHash))
+
+	token := withToken("admin")
+
+	var swarms []AdminSwarm
+	w := serveRequest(r, http.MethodGet, "/admin/swarms?room=admin-test", token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	decodeJSON(t, w, &swarms)
+	assert.Equal(t, []AdminSwarm{{Room: "admin-test", InfoHash: hex.EncodeToString([]byte(infoHash)), Seeders: 1, Leechers: 1}}, swarms)
+	var rooms []AdminRoom
+	w = serveRequest(r, http.MethodGet, "/admin/rooms", token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	decodeJSON(t, w, &rooms)
+	assert.Contains(t, rooms, AdminRoom{Room: "admin-test", Swarms: 1, Seeders: 1, Leechers: 1})
+
+	var sw AdminSwarm
+	w = serveRequest(r, http.MethodGet, path, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	decodeJSON(t, w, &sw)
+	assert.Len(t, sw.Peers, 2)
+	for _, p := range sw.Peers {
+		if p.Seeding {
+			assert.Equal(t, "10.0.0.1", p.IP)
+			assert.Equal(t, "rack1", p.Zone)
+			assert.Equal(t, hex.EncodeToString([]byte(strings.Repeat("p", 20))), p.PeerID)
+			assert.True(t, p.Expires.After(p.LastSeen))
+		}
+	}
+
+	assert.Equal(t, http.StatusBadRequest, serveRequest(r, http.MethodDelete, path+"/peers/fd00::1", token).Code)
+	assert.Equal(t, http.StatusNoContent, serveRequest(r, http.MethodDelete, path+"/peers/[fd00::1]:6882", token).Code)
+	assert.Equal(t, http.StatusNotFound, serveRequest(r, http.MethodDelete, path+"/peers/[fd00::1]:6882", token).Code)
+	_, leechers := GetStats("admin-test", infoHash)
+	assert.Equal(t, 0, leechers)
+
+	assert.Equal(t, http.StatusNoContent, serveRequest(r, http.MethodDelete, path, token).Code)
+	assert.Equal(t, http.StatusNotFound, serveRequest(r, http.MethodGet, path, token).Code)
+	assert.Equal(t, http.StatusBadRequest, serveRequest(r, http.MethodGet, "/admin/swarms/admin-test/xyz", token).Code)
+
+	assert.Equal(t, http.StatusOK, serveRequest(r, http.MethodGet, "/admin/", token).Code)
+}
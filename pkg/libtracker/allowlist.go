@@ -0,0 +1,129 @@
+package libtracker
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errUnregisteredTorrent = errors.New("unregistered torrent")
+
+// allowlist 中的 torrent 以 room 和 hex 编码的 info hash 为 key
+var allowlist = struct {
+	sync.RWMutex
+	enabled bool
+	m       map[allowKey]bool
+}{m: make(map[allowKey]bool)}
+
+type allowKey struct {
+	room     string
+	infoHash string
+}
+
+var adminToken = struct {
+	sync.RWMutex
+	token string
+}{}
+
+// EnableAllowlist makes tracker only accept announces and scrapes of torrents registered by AllowTorrent
+// or the admin API.
+func EnableAllowlist() {
+	allowlist.Lock()
+	allowlist.enabled = true
+	allowlist.Unlock()
+}
+
+// AllowTorrent registers torrent infoHash (raw 20 bytes) in room.
+func AllowTorrent(room, infoHash string) {
+	allowlist.Lock()
+	allowlist.m[allowKey{room, hex.EncodeToString([]byte(infoHash))}] = true
+	allowlist.Unlock()
+}
+
+// DisallowTorrent unregisters torrent infoHash (raw 20 bytes) in room.
+func DisallowTorrent(room, infoHash string) {
+	allowlist.Lock()
+	delete(allowlist.m, allowKey{room, hex.EncodeToString([]byte(infoHash))})
+	allowlist.Unlock()
+}
+
+// SetAdminToken enables the admin API, requests must carry header `Authorization: Bearer <token>`.
+func SetAdminToken(token string) {
+	adminToken.Lock()
+	adminToken.token = token
+	adminToken.Unlock()
+}
+
+func getAdminToken() string {
+	adminToken.RLock()
+	defer adminToken.RUnlock()
+	return adminToken.token
+}
+
+// allowed 判断 torrent 是否已经注册，没有开启 allowlist 时总是返回 nil
+func allowed(room, infoHash string) error {
+	allowlist.RLock()
+	defer allowlist.RUnlock()
+	if !allowlist.enabled || allowlist.m[allowKey{room, hex.EncodeToString([]byte(infoHash))}] {
+		return nil
+	}
+	return errUnregisteredTorrent
+}
+
+// adminAuth 校验 admin API 的 token，没有设置 token 时 admin API 不可用
+func adminAuth(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	adminToken := getAdminToken()
+	if adminToken == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+		return
+	}
+}
+
+// AllowlistEntry 是 allowlist 中的一个 torrent
+type AllowlistEntry struct {
+	Room string `json:"room"`
+	// hex 编码的 info hash
+	InfoHash string `json:"info_hash"`
+}
+
+func listAllowlist(c *gin.Context) {
+	allowlist.RLock()
+	entries := make([]AllowlistEntry, 0, len(allowlist.m))
+	for k := range allowlist.m {
+		entries = append(entries, AllowlistEntry{Room: k.room, InfoHash: k.infoHash})
+	}
+	enabled := allowlist.enabled
+	allowlist.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Room != entries[j].Room {
+			return entries[i].Room < entries[j].Room
+		}
+		return entries[i].InfoHash < entries[j].InfoHash
+	})
+	c.JSON(http.StatusOK, gin.H{"enabled": enabled, "torrents": entries})
+}
+
+func updateAllowlist(c *gin.Context) {
+	ih, err := hex.DecodeString(c.Param("info_hash"))
+	if err != nil || len(ih) != 20 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "info_hash must be 40 hex characters"})
+		return
+	}
+	if c.Request.Method == http.MethodDelete {
+		DisallowTorrent(c.Param("room"), string(ih))
+	} else {
+		AllowTorrent(c.Param("room"), string(ih))
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package libtracker
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowlist(t *testing.T) {
+	infoHash := strings.Repeat("a", 20)
+	assert.NoError(t, allowed("room", infoHash))
+
+	EnableAllowlist()
+	defer func() {
+		allowlist.Lock()
+		allowlist.enabled = false
+		allowlist.m = make(map[allowKey]bool)
+		allowlist.Unlock()
+	}()
+	assert.Equal(t, errUnregisteredTorrent, allowed("room", infoHash))
+	AllowTorrent("room", infoHash)
+	assert.NoError(t, allowed("room", infoHash))
+	assert.Equal(t, errUnregisteredTorrent, allowed("other", infoHash))
+	DisallowTorrent("room", infoHash)
+	assert.Equal(t, errUnregisteredTorrent, allowed("room", infoHash))
+}
+
+func TestAdminAllowlist(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	admin := r.Group("/admin", adminAuth)
+	admin.GET("/allowlist", listAllowlist)
+	admin.PUT("/allowlist/:room/:info_hash", updateAllowlist)
+	admin.DELETE("/allowlist/:room/:info_hash", updateAllowlist)
+
+	infoHash := strings.Repeat("b", 20)
+	path := "/admin/allowlist/room/" + hex.EncodeToString([]byte(infoHash))
+	token := withToken("admin")
+
+	// 没有设置 token 时 admin API 不可用
+	assert.Equal(t, http.StatusNotFound, serveRequest(r, http.MethodPut, path).Code)
+
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+	assert.Equal(t, http.StatusUnauthorized, serveRequest(r, http.MethodPut, path, withToken("wrong")).Code)
+	assert.Equal(t, http.StatusBadRequest, serveRequest(r, http.MethodPut, "/admin/allowlist/room/xyz", token).Code)
+
+	assert.Equal(t, http.StatusNoContent, serveRequest(r, http.MethodPut, path, token).Code)
+	w := serveRequest(r, http.MethodGet, "/admin/allowlist", token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), hex.EncodeToString([]byte(infoHash)))
+
+	EnableAllowlist()
+	defer func() {
+		allowlist.Lock()
+		allowlist.enabled = false
+		allowlist.Unlock()
+	}()
+	assert.NoError(t, allowed("room", infoHash))
+	assert.Equal(t, http.StatusNoContent, serveRequest(r, http.MethodDelete, path, token).Code)
+	assert.Equal(t, errUnregisteredTorrent, allowed("room", infoHash))
+}
@@ -0,0 +1,68 @@
+package libtracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackpal/bencode-go"
+)
+
+var (
+	errMissingToken = errors.New("missing token")
+	errInvalidToken = errors.New("invalid token")
+)
+
+var secrets = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// SetRoomSecret requires announce, scrape and report requests of room to carry a token signed with secret,
+// see Token. Rooms without secret accept all requests.
+func SetRoomSecret(room, secret string) {
+	secrets.Lock()
+	secrets.m[room] = secret
+	secrets.Unlock()
+}
+
+// Token returns the HMAC-SHA256 token of infoHash (raw 20 bytes) in room, which is passed as `token` query param
+// of tracker url. Token with empty infoHash grants access to report of all torrents in room.
+func Token(secret, room, infoHash string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(room))
+	mac.Write([]byte{0})
+	mac.Write([]byte(infoHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authorize 校验请求的 token，room 没有设置 secret 时不校验
+func authorize(room, infoHash, token string) error {
+	secrets.RLock()
+	secret, ok := secrets.m[room]
+	secrets.RUnlock()
+	if !ok {
+		return nil
+	}
+	if token == "" {
+		return errMissingToken
+	}
+	if !hmac.Equal([]byte(token), []byte(Token(secret, room, infoHash))) {
+		return errInvalidToken
+	}
+	return nil
+}
+
+type failureResponse struct {
+	FailureReason string `bencode:"failure reason"`
+}
+
+// failure 返回 BEP 3 的 failure reason，HTTP 状态码仍为 200，以便客户端读取原因
+func failure(c *gin.Context, reason string) {
+	if err := bencode.Marshal(c.Writer, failureResponse{FailureReason: reason}); err != nil {
+		c.Error(err)
+	}
+}
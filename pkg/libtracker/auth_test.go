@@ -0,0 +1,22 @@
+package libtracker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	infoHash := string(make([]byte, 20))
+	assert.NoError(t, authorize("open", infoHash, ""))
+
+	SetRoomSecret("private", "secret")
+	token := Token("secret", "private", infoHash)
+	assert.NoError(t, authorize("private", infoHash, token))
+	assert.Equal(t, errMissingToken, authorize("private", infoHash, ""))
+	assert.Equal(t, errInvalidToken, authorize("private", infoHash, Token("other", "private", infoHash)))
+	// token 只对签名的 room 和 info hash 有效
+	assert.Equal(t, errInvalidToken, authorize("private", "", token))
+	SetRoomSecret("private2", "secret")
+	assert.Equal(t, errInvalidToken, authorize("private2", infoHash, token))
+}
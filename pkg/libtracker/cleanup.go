@@ -0,0 +1,165 @@
+package libtracker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultExpiryFactor = 2
+	defaultExpiryGrace  = 30 * time.Second
+	defaultSweepPeriod  = time.Minute
+	defaultHistoryTTL   = 24 * time.Hour
+	// cleanupBatch 是每次加锁处理的 swarm 数量，避免 swarm 很多时长时间阻塞 announce
+	cleanupBatch = 64
+)
+
+// ExpiryPolicy decides when a peer which stopped announcing is removed from swarm.
+type ExpiryPolicy struct {
+	// peer 超过 Factor 个 announce interval 加上 Grace 没有 announce 时过期，0 表示 2
+	Factor float64
+	// 0 表示 30s
+	Grace time.Duration
+	// 清理所有 shard 一次的周期，每次只清理一个 shard，0 表示 1min。只在 Cleanup 启动时生效
+	SweepPeriod time.Duration
+	// 下载记录在最近一次 announce 后保留的时间，0 表示 24h
+	History time.Duration
+}
+
+var expiryPolicy = struct {
+	sync.RWMutex
+	ExpiryPolicy
+}{}
+
+// SetExpiryPolicy sets the peer expiry of all rooms.
+func SetExpiryPolicy(p ExpiryPolicy) {
+	expiryPolicy.Lock()
+	expiryPolicy.ExpiryPolicy = p
+	expiryPolicy.Unlock()
+}
+
+func (p ExpiryPolicy) withDefaults() ExpiryPolicy {
+	if p.Factor <= 0 {
+		p.Factor = defaultExpiryFactor
+	}
+	if p.Grace <= 0 {
+		p.Grace = defaultExpiryGrace
+	}
+	if p.SweepPeriod <= 0 {
+		p.SweepPeriod = defaultSweepPeriod
+	}
+	if p.History <= 0 {
+		p.History = defaultHistoryTTL
+	}
+	return p
+}
+
+// peerTTL 返回 peer 在 swarm 中的有效期，和返回给该 peer 的 interval 使用相同的计算方式
+func peerTTL(numSeeders, numLeechers int) time.Duration {
+	expiryPolicy.RLock()
+	p := expiryPolicy.ExpiryPolicy.withDefaults()
+	expiryPolicy.RUnlock()
+	interval, _ := announceInterval(numSeeders, numLeechers, currentAnnounceRate())
+	return time.Duration(float64(interval)*p.Factor) + p.Grace
+}
+
+// CleanupStats counts peers and swarms removed by Cleanup.
+type CleanupStats struct {
+	// 过期的 peer 数量，不包括发送 stopped 事件的 peer
+	EvictedPeers uint64 `json:"evicted_peers"`
+	// 删除的没有 peer 和下载记录的 swarm 数量
+	RemovedSwarms uint64 `json:"removed_swarms"`
+	// 完成的完整清理次数
+	Sweeps uint64 `json:"sweeps"`
+}
+
+var cleanupStats CleanupStats
+
+// GetCleanupStats returns the counts since tracker started.
+func GetCleanupStats() CleanupStats {
+	return CleanupStats{
+		EvictedPeers:  atomic.LoadUint64(&cleanupStats.EvictedPeers),
+		RemovedSwarms: atomic.LoadUint64(&cleanupStats.RemovedSwarms),
+		Sweeps:        atomic.LoadUint64(&cleanupStats.Sweeps),
+	}
+}
+
+// Cleanup removes expired peers incrementally: one shard per tick, a full sweep takes ExpiryPolicy.SweepPeriod.
+// Idle rate limiters are removed after each full sweep.
+func Cleanup() {
+	expiryPolicy.RLock()
+	period := expiryPolicy.ExpiryPolicy.withDefaults().SweepPeriod
+	expiryPolicy.RUnlock()
+	ticker := time.NewTicker(period / time.Duration(len(shards)))
+	defer ticker.Stop()
+	for i := 0; ; i = (i + 1) % len(shards) {
+		<-ticker.C
+		evicted, removed := cleanupShard(shards[i], time.Now().Unix())
+		atomic.AddUint64(&cleanupStats.EvictedPeers, uint64(evicted))
+		atomic.AddUint64(&cleanupStats.RemovedSwarms, uint64(removed))
+		if i == len(shards)-1 {
+			atomic.AddUint64(&cleanupStats.Sweeps, 1)
+			cleanupLimiters()
+			stats := GetCleanupStats()
+			log.Debugf("Tracker cleanup: %d peers evicted, %d swarms removed in %d sweeps",
+				stats.EvictedPeers, stats.RemovedSwarms, stats.Sweeps)
+		}
+	}
+}
+
+// cleanupShard 删除 shard 中过期的 peer 和下载记录，以及没有 peer 和下载记录的 swarm。
+// 先在读锁下取出所有 swarm，再每 cleanupBatch 个 swarm 加一次写锁
+func cleanupShard(shard *shard, now int64) (evicted, removed int) {
+	expiryPolicy.RLock()
+	historyExpiration := now - int64(expiryPolicy.ExpiryPolicy.withDefaults().History/time.Second)
+	expiryPolicy.RUnlock()
+	shard.RLock()
+	hashes := make([]hash, 0, len(shard.swarms))
+	for h := range shard.swarms {
+		hashes = append(hashes, h)
+	}
+	shard.RUnlock()
+	for len(hashes) > 0 {
+		n := cleanupBatch
+		if n > len(hashes) {
+			n = len(hashes)
+		}
+		shard.Lock()
+		for _, h := range hashes[:n] {
+			swarm, ok := shard.swarms[h]
+			if !ok {
+				continue
+			}
+			expired := 0
+			for _, peers := range []map[serializedPeer]peer{swarm.seeders, swarm.leechers} {
+				for addr, p := range peers {
+					if p.expires < now {
+						delete(peers, addr)
+						expired++
+					}
+				}
+			}
+			evicted += expired
+			records := 0
+			for key, r := range swarm.history {
+				if r.LastSeen.Unix() < historyExpiration {
+					delete(swarm.history, key)
+					records++
+				}
+			}
+			swarms := 0
+			if len(swarm.leechers) == 0 && len(swarm.seeders) == 0 && len(swarm.history) == 0 {
+				delete(shard.swarms, h)
+				swarms = 1
+				removed++
+			}
+			release(swarm.room, swarms, expired, records)
+		}
+		shard.Unlock()
+		hashes = hashes[n:]
+	}
+	return
+}
@@ -0,0 +1,118 @@
+package libtracker
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerTTL(t *testing.T) {
+	// interval 15s * 2 + 30s
+	assert.Equal(t, time.Minute, peerTTL(0, 1))
+	assert.Equal(t, 90*time.Second, peerTTL(1, 1))
+	SetExpiryPolicy(ExpiryPolicy{Factor: 3, Grace: time.Second})
+	defer SetExpiryPolicy(ExpiryPolicy{})
+	assert.Equal(t, 91*time.Second, peerTTL(1, 1))
+}
+
+func TestCleanupShard(t *testing.T) {
+	infoHash := strings.Repeat("h", 20)
+	PutPeer("cleanup", infoHash, "", "", "10.0.0.1", 6881, true)
+	PutPeer("cleanup", infoHash, "", "", "10.0.0.2", 6881, false)
+	h := sha1.Sum([]byte("cleanup" + infoHash))
+	shard := shards[shardIndex(h)]
+
+	now := time.Now()
+	evicted, removed := cleanupShard(shard, now.Unix())
+	assert.Equal(t, 0, evicted)
+	assert.Equal(t, 0, removed)
+	// leecher 加入时有 2 个 peer，有效期更长
+	PutPeer("cleanup", infoHash, "", "", "10.0.0.3", 6881, false)
+	evicted, removed = cleanupShard(shard, now.Add(95*time.Second).Unix())
+	assert.Equal(t, 2, evicted)
+	assert.Equal(t, 0, removed)
+	seeders, leechers := GetStats("cleanup", infoHash)
+	assert.Equal(t, 0, seeders)
+	assert.Equal(t, 1, leechers)
+	evicted, removed = cleanupShard(shard, now.Add(time.Hour).Unix())
+	assert.Equal(t, 1, evicted)
+	assert.Equal(t, 1, removed)
+}
+
+func TestCleanupShardHistory(t *testing.T) {
+	infoHash := strings.Repeat("r", 20)
+	assert.NoError(t, RecordAnnounce("cleanup-history", &AnnounceRequest{InfoHash: infoHash, PeerID: "a", IP: "10.0.0.1", Port: 6881}))
+	h := sha1.Sum([]byte("cleanup-history" + infoHash))
+	shard := shards[shardIndex(h)]
+
+	now := time.Now()
+	// 没有 peer，但下载记录还没有过期
+	_, removed := cleanupShard(shard, now.Add(time.Hour).Unix())
+	assert.Equal(t, 0, removed)
+	assert.Equal(t, 1, len(GetReport("cleanup-history", infoHash).Torrents))
+	SetExpiryPolicy(ExpiryPolicy{History: time.Minute})
+	defer SetExpiryPolicy(ExpiryPolicy{})
+	_, removed = cleanupShard(shard, now.Add(time.Hour).Unix())
+	assert.Equal(t, 1, removed)
+	assert.Empty(t, GetReport("cleanup-history", infoHash).Torrents)
+}
+
+// TestAnnounceCleanup 通过 announce 写入 peer 和下载记录，swarm 在下载记录过期后才删除
+func TestAnnounceCleanup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/:room/announce", announce)
+	infoHash := strings.Repeat("c", 20)
+	announceURL := func(peerID, event string) string {
+		return "/announce-cleanup/announce?info_hash=" + url.QueryEscape(infoHash) +
+			"&peer_id=" + peerID + "&port=6881&left=1&event=" + event
+	}
+	addr := withRemoteAddr("10.0.0.1:1234")
+	h := sha1.Sum([]byte("announce-cleanup" + infoHash))
+	shard := shards[shardIndex(h)]
+
+	for _, event := range []string{"started", "stopped"} {
+		w := serveRequest(r, http.MethodGet, announceURL("a", event), addr)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, failureReason(t, w))
+	}
+	seeders, leechers := GetStats("announce-cleanup", infoHash)
+	assert.Equal(t, 0, seeders+leechers)
+	now := time.Now()
+	evicted, removed := cleanupShard(shard, now.Add(time.Hour).Unix())
+	assert.Equal(t, 0, evicted)
+	assert.Equal(t, 0, removed)
+	assert.Len(t, GetReport("announce-cleanup", infoHash).Torrents, 1)
+
+	// 没有 stopped 事件的 peer 先过期，下载记录保留到 History 之后
+	w := serveRequest(r, http.MethodGet, announceURL("b", "started"), addr)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, failureReason(t, w))
+	evicted, removed = cleanupShard(shard, now.Add(time.Hour).Unix())
+	assert.Equal(t, 1, evicted)
+	assert.Equal(t, 0, removed)
+	evicted, removed = cleanupShard(shard, now.Add(defaultHistoryTTL+time.Minute).Unix())
+	assert.Equal(t, 0, evicted)
+	assert.Equal(t, 1, removed)
+	assert.Empty(t, GetReport("announce-cleanup", infoHash).Torrents)
+}
+
+func TestCleanupShardBatches(t *testing.T) {
+	shard := NewShards(1)[0]
+	for i := 0; i < 3*cleanupBatch+1; i++ {
+		sw := newSwarm("batch", fmt.Sprint(i))
+		sw.put(serialize("10.0.0.1", 6881), "", "", true)
+		shard.swarms[sha1.Sum([]byte(fmt.Sprint(i)))] = sw
+	}
+	evicted, removed := cleanupShard(shard, time.Now().Add(time.Hour).Unix())
+	assert.Equal(t, 3*cleanupBatch+1, evicted)
+	assert.Equal(t, 3*cleanupBatch+1, removed)
+	assert.Empty(t, shard.swarms)
+}
@@ -0,0 +1,118 @@
+package libtracker
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errInvalidIP = errors.New("invalid ip")
+
+// IPPolicy decides which address of an announcing peer is registered.
+// By default the address of the TCP connection is used.
+type IPPolicy struct {
+	// 信任这些网段的客户端 announce 的 ip 参数，如 NAT 后的节点上报的外部地址
+	TrustIPParam []*net.IPNet
+	// 信任这些代理的 X-Forwarded-For header，如负载均衡
+	TrustedProxies []*net.IPNet
+}
+
+var ipPolicies = struct {
+	sync.RWMutex
+	def   IPPolicy
+	rooms map[string]IPPolicy
+}{rooms: make(map[string]IPPolicy)}
+
+// SetIPPolicy sets the default policy of all rooms.
+func SetIPPolicy(policy IPPolicy) {
+	ipPolicies.Lock()
+	ipPolicies.def = policy
+	ipPolicies.Unlock()
+}
+
+// SetRoomIPPolicy overrides the default policy of room.
+func SetRoomIPPolicy(room string, policy IPPolicy) {
+	ipPolicies.Lock()
+	ipPolicies.rooms[room] = policy
+	ipPolicies.Unlock()
+}
+
+func ipPolicy(room string) IPPolicy {
+	ipPolicies.RLock()
+	defer ipPolicies.RUnlock()
+	if policy, ok := ipPolicies.rooms[room]; ok {
+		return policy
+	}
+	return ipPolicies.def
+}
+
+// ParseIPNets parses CIDRs, a single IP is parsed as /32 or /128.
+func ParseIPNets(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP 根据 room 的策略返回 peer 的地址：
+// 连接来自可信代理时，从右向左跳过可信代理，取 X-Forwarded-For 中第一个不可信的地址；
+// 该地址在 TrustIPParam 中时使用 ip 参数，ip 参数不是 IP 地址（如主机名）时忽略。
+func peerIP(c *gin.Context, room, ipParam string) (string, error) {
+	policy := ipPolicy(room)
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		return "", errInvalidIP
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", errInvalidIP
+	}
+	if containsIP(policy.TrustedProxies, ip) {
+		forwarded := strings.Split(strings.Join(c.Request.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			addr := net.ParseIP(strings.TrimSpace(forwarded[i]))
+			if addr == nil {
+				break
+			}
+			ip = addr
+			if !containsIP(policy.TrustedProxies, ip) {
+				break
+			}
+		}
+	}
+	if ipParam != "" && containsIP(policy.TrustIPParam, ip) {
+		if addr := net.ParseIP(ipParam); addr != nil {
+			ip = addr
+		}
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,62 @@
+package libtracker
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerIP(t *testing.T) {
+	nets := func(cidrs ...string) []*net.IPNet {
+		n, err := ParseIPNets(cidrs)
+		assert.NoError(t, err)
+		return n
+	}
+	SetIPPolicy(IPPolicy{TrustedProxies: nets("192.168.0.1", "192.168.1.0/24")})
+	SetRoomIPPolicy("nat", IPPolicy{TrustIPParam: nets("10.0.0.0/8", "fd00::/8")})
+	SetRoomIPPolicy("proxied", IPPolicy{TrustedProxies: nets("192.168.0.1"), TrustIPParam: nets("10.0.0.0/8")})
+	defer func() {
+		SetIPPolicy(IPPolicy{})
+		ipPolicies.Lock()
+		ipPolicies.rooms = make(map[string]IPPolicy)
+		ipPolicies.Unlock()
+	}()
+
+	peerIPOf := func(room, remoteAddr, forwarded, ipParam string) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			c.Request.Header.Set("X-Forwarded-For", forwarded)
+		}
+		ip, err := peerIP(c, room, ipParam)
+		if err != nil {
+			return err.Error()
+		}
+		return ip
+	}
+
+	// 默认使用连接的地址
+	assert.Equal(t, "1.2.3.4", peerIPOf("1", "1.2.3.4:1000", "5.6.7.8", "9.9.9.9"))
+	// 可信代理，跳过多层可信代理
+	assert.Equal(t, "5.6.7.8", peerIPOf("1", "192.168.0.1:1000", "5.6.7.8", ""))
+	assert.Equal(t, "5.6.7.8", peerIPOf("1", "192.168.0.1:1000", "6.6.6.6, 5.6.7.8, 192.168.1.2", ""))
+	assert.Equal(t, "192.168.0.1", peerIPOf("1", "192.168.0.1:1000", "", ""))
+	// room 的策略覆盖默认策略
+	assert.Equal(t, "192.168.0.1", peerIPOf("nat", "192.168.0.1:1000", "5.6.7.8", ""))
+	assert.Equal(t, "100.64.0.1", peerIPOf("nat", "10.0.0.1:1000", "", "100.64.0.1"))
+	assert.Equal(t, "2001:db8::1", peerIPOf("nat", "[fd00::2]:1000", "", "2001:db8::1"))
+	assert.Equal(t, "10.0.0.1", peerIPOf("nat", "10.0.0.1:1000", "", ""))
+	// ip 参数不是 IP 地址时使用连接或 X-Forwarded-For 的地址
+	assert.Equal(t, "10.0.0.1", peerIPOf("nat", "10.0.0.1:1000", "", "example.com"))
+	assert.Equal(t, "10.0.0.5", peerIPOf("proxied", "192.168.0.1:1000", "10.0.0.5", "example.com"))
+	assert.Equal(t, "100.64.0.1", peerIPOf("proxied", "192.168.0.1:1000", "10.0.0.5", "100.64.0.1"))
+	assert.Equal(t, "11.0.0.1", peerIPOf("nat", "11.0.0.1:1000", "", "100.64.0.1"))
+
+	_, err := ParseIPNets([]string{"10.0.0.300"})
+	assert.Error(t, err)
+}
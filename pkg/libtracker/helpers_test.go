@@ -0,0 +1,55 @@
+package libtracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackpal/bencode-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveRequest 通过 r 处理请求并返回响应，opts 用于设置 token、客户端地址等
+func serveRequest(r http.Handler, method, target string, opts ...func(*http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for _, opt := range opts {
+		opt(req)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// withToken 设置 admin API 的 bearer token
+func withToken(token string) func(*http.Request) {
+	return func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// withRemoteAddr 设置客户端地址，默认为 httptest 的 192.0.2.1:1234
+func withRemoteAddr(addr string) func(*http.Request) {
+	return func(req *http.Request) {
+		req.RemoteAddr = addr
+	}
+}
+
+// decodeResponse 解码 bencode 格式的 tracker 响应
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	resp, err := bencode.Decode(w.Body)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	return resp.(map[string]interface{})
+}
+
+// failureReason 返回 tracker 响应中的 failure reason，没有时为 nil
+func failureReason(t *testing.T, w *httptest.ResponseRecorder) interface{} {
+	return decodeResponse(t, w)["failure reason"]
+}
+
+// decodeJSON 解码 admin API 的 JSON 响应
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+}
@@ -0,0 +1,174 @@
+package libtracker
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// PeerRecord 是一个 peer 在 swarm 中的下载记录，来自 announce 的参数
+type PeerRecord struct {
+	// hex 编码的 peer_id
+	PeerID string `json:"peer_id"`
+	IP     string `json:"ip"`
+	Port   uint16 `json:"port"`
+	// 第一次及最近一次 announce 的时间
+	Started  time.Time `json:"started"`
+	LastSeen time.Time `json:"last_seen"`
+	// 下载完成的时间，启动时已经完成下载的 peer（如做种节点）为空
+	Completed  *time.Time `json:"completed,omitempty"`
+	Uploaded   uint       `json:"uploaded"`
+	Downloaded uint       `json:"downloaded"`
+	Left       uint       `json:"left"`
+	// 最近一次 announce 的事件，为空表示定期 announce
+	Event string `json:"event"`
+}
+
+// TorrentReport 是一个 torrent 的下载情况
+type TorrentReport struct {
+	// hex 编码的 info hash
+	InfoHash  string       `json:"info_hash"`
+	Seeders   int          `json:"seeders"`
+	Leechers  int          `json:"leechers"`
+	Completed int          `json:"completed"`
+	Peers     []PeerRecord `json:"peers"`
+	// 预期节点的状态，见 SetRoster
+	Hosts []HostStatus `json:"hosts,omitempty"`
+}
+
+// Report 是 /:room/report 接口的返回值
+type Report struct {
+	Torrents []TorrentReport `json:"torrents"`
+}
+
+// RecordAnnounce 更新 peer 的下载记录，torrent 或下载记录的数量超过限制时返回错误，见 Limits
+func RecordAnnounce(room string, req *AnnounceRequest) error {
+	h := sha1.Sum([]byte(room + req.InfoHash))
+	shard := shards[shardIndex(h)]
+	shard.Lock()
+	defer shard.Unlock()
+	sw, err := shard.getOrCreate(h, room, req.InfoHash)
+	if err != nil {
+		return err
+	}
+	key := req.PeerID
+	if key == "" {
+		key = net.JoinHostPort(req.IP, strconv.Itoa(int(req.Port)))
+	}
+	now := time.Now()
+	r, ok := sw.history[key]
+	if !ok {
+		// 下载记录保留的时间比 peer 长，和 peer 数量使用相同的限制，创建前先检查
+		if err := reserve(room, 0, 0, 1); err != nil {
+			return err
+		}
+		r = &PeerRecord{PeerID: hex.EncodeToString([]byte(req.PeerID)), Started: now}
+		sw.history[key] = r
+	}
+	// 错过了 completed 事件时，根据 left 判断是否完成
+	if r.Completed == nil && (req.Event == "completed" || (ok && r.Left > 0 && req.Left == 0)) {
+		r.Completed = &now
+	}
+	r.IP = req.IP
+	r.Port = req.Port
+	r.LastSeen = now
+	r.Uploaded = req.Uploaded
+	r.Downloaded = req.Downloaded
+	r.Left = req.Left
+	r.Event = req.Event
+	return nil
+}
+
+// GetCompleted returns the number of peers that have completed downloading.
+// Peers are identified by IP and port, a restarted peer is counted once.
+func GetCompleted(room, infoHash string) int {
+	h := sha1.Sum([]byte(room + infoHash))
+	shard := shards[shardIndex(h)]
+	shard.RLock()
+	defer shard.RUnlock()
+	return countCompleted(shard.swarms[h].history)
+}
+
+// CompletedPeers returns the addresses (ip:port) of peers that have completed downloading, see GetCompleted.
+func CompletedPeers(room, infoHash string) []string {
+	h := sha1.Sum([]byte(room + infoHash))
+	shard := shards[shardIndex(h)]
+	shard.RLock()
+	addrs := completedPeers(shard.swarms[h].history)
+	shard.RUnlock()
+	peers := make([]string, 0, len(addrs))
+	for addr := range addrs {
+		peers = append(peers, addr)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+func completedPeers(history map[string]*PeerRecord) map[string]bool {
+	addrs := make(map[string]bool)
+	for _, r := range history {
+		if r.Completed != nil {
+			addrs[net.JoinHostPort(r.IP, strconv.Itoa(int(r.Port)))] = true
+		}
+	}
+	return addrs
+}
+
+func countCompleted(history map[string]*PeerRecord) int {
+	return len(completedPeers(history))
+}
+
+// GetReport returns the download report of all torrents in room, or the torrent infoHash if it isn't empty.
+func GetReport(room, infoHash string) Report {
+	report := Report{Torrents: []TorrentReport{}}
+	for _, shard := range shards {
+		shard.RLock()
+		for _, sw := range shard.swarms {
+			if sw.room != room || (infoHash != "" && sw.infoHash != infoHash) {
+				continue
+			}
+			t := TorrentReport{
+				InfoHash:  hex.EncodeToString([]byte(sw.infoHash)),
+				Seeders:   len(sw.seeders),
+				Leechers:  len(sw.leechers),
+				Completed: countCompleted(sw.history),
+				Peers:     make([]PeerRecord, 0, len(sw.history)),
+			}
+			for _, r := range sw.history {
+				t.Peers = append(t.Peers, *r)
+			}
+			sort.Slice(t.Peers, func(i, j int) bool { return t.Peers[i].Started.Before(t.Peers[j].Started) })
+			report.Torrents = append(report.Torrents, t)
+		}
+		shard.RUnlock()
+	}
+	sort.Slice(report.Torrents, func(i, j int) bool { return report.Torrents[i].InfoHash < report.Torrents[j].InfoHash })
+	for i := range report.Torrents {
+		report.Torrents[i].Hosts = hostStatus(room, report.Torrents[i].Peers)
+	}
+	return report
+}
+
+// FetchReport gets report from tracker, url is like http://<host>:<port>/<room>/report.
+func FetchReport(url string) (*Report, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+	report := new(Report)
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,40 @@
+package libtracker
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAnnounce(t *testing.T) {
+	room := "history"
+	infoHash := string(make([]byte, 20))
+	announce := func(peerID, ip string, left uint, event string) {
+		RecordAnnounce(room, &AnnounceRequest{InfoHash: infoHash, PeerID: peerID, IP: ip, Port: 6881, Left: left, Event: event})
+	}
+	announce("seeder", "10.0.0.1", 0, "started")
+	announce("peer-a", "10.0.0.2", 100, "started")
+	announce("peer-a", "10.0.0.2", 0, "completed")
+	// 错过 completed 事件
+	announce("peer-b", "10.0.0.3", 100, "started")
+	announce("peer-b", "10.0.0.3", 0, "")
+	// 重启后同一地址只统计一次
+	announce("peer-c", "10.0.0.2", 0, "completed")
+	announce("peer-d", "10.0.0.4", 100, "started")
+
+	assert.Equal(t, 2, GetCompleted(room, infoHash))
+	assert.Equal(t, []string{"10.0.0.2:6881", "10.0.0.3:6881"}, CompletedPeers(room, infoHash))
+	assert.Empty(t, CompletedPeers(room, "other"))
+	report := GetReport(room, "")
+	if assert.Len(t, report.Torrents, 1) {
+		tr := report.Torrents[0]
+		assert.Equal(t, hex.EncodeToString([]byte(infoHash)), tr.InfoHash)
+		assert.Equal(t, 2, tr.Completed)
+		assert.Len(t, tr.Peers, 5)
+		assert.Equal(t, hex.EncodeToString([]byte("seeder")), tr.Peers[0].PeerID)
+		assert.Nil(t, tr.Peers[0].Completed)
+	}
+	assert.Empty(t, GetReport(room, "other").Torrents)
+	assert.Empty(t, GetReport("other", "").Torrents)
+}
@@ -0,0 +1,108 @@
+package libtracker
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMinInterval     = 15 * time.Second
+	defaultMaxInterval     = 30 * time.Minute
+	defaultMaxAnnounceRate = 500
+	// loadWindow 是统计 announce 速率的周期
+	loadWindow = 10 * time.Second
+)
+
+// IntervalPolicy bounds the announce interval returned by tracker.
+// The interval grows logarithmically with swarm size, and is extended when announce rate of tracker exceeds MaxAnnounceRate.
+type IntervalPolicy struct {
+	// interval 和 min interval 的下限，0 表示 15s
+	Min time.Duration
+	// interval 的上限，0 表示 30min
+	Max time.Duration
+	// tracker 每秒处理的 announce 数量超过该值时按比例延长 interval，0 表示 500
+	MaxAnnounceRate float64
+}
+
+var intervalPolicy = struct {
+	sync.RWMutex
+	IntervalPolicy
+}{}
+
+// SetIntervalPolicy sets the announce interval bounds of all rooms.
+func SetIntervalPolicy(p IntervalPolicy) {
+	intervalPolicy.Lock()
+	intervalPolicy.IntervalPolicy = p
+	intervalPolicy.Unlock()
+}
+
+func (p IntervalPolicy) withDefaults() IntervalPolicy {
+	if p.Min <= 0 {
+		p.Min = defaultMinInterval
+	}
+	if p.Max <= 0 {
+		p.Max = defaultMaxInterval
+	}
+	if p.Max < p.Min {
+		p.Max = p.Min
+	}
+	if p.MaxAnnounceRate <= 0 {
+		p.MaxAnnounceRate = defaultMaxAnnounceRate
+	}
+	return p
+}
+
+// announceCount 是当前周期的 announce 数量，announceRate 是上一个周期每秒的 announce 数量（math.Float64bits）
+var announceCount, announceRate uint64
+
+// MeasureLoad updates announce rate of tracker periodically, used by interval calculation.
+func MeasureLoad() {
+	for {
+		time.Sleep(loadWindow)
+		count := atomic.SwapUint64(&announceCount, 0)
+		atomic.StoreUint64(&announceRate, math.Float64bits(float64(count)/loadWindow.Seconds()))
+	}
+}
+
+func countAnnounce() {
+	atomic.AddUint64(&announceCount, 1)
+}
+
+func currentAnnounceRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&announceRate))
+}
+
+// announceInterval 根据 swarm 大小和 tracker 负载计算 interval 和 min interval：
+// 1 个 peer 时为 Min，peer 数量每增加一倍增加一个 Min；没有 seeder 时减半以尽快发现 seeder，没有 leecher 时加倍；
+// announce 速率超过 MaxAnnounceRate 时按比例延长。min interval 为 interval 的一半，都不小于 Min。
+func announceInterval(numSeeders, numLeechers int, rate float64) (interval, minInterval time.Duration) {
+	intervalPolicy.RLock()
+	p := intervalPolicy.IntervalPolicy.withDefaults()
+	intervalPolicy.RUnlock()
+
+	n := math.Max(1, float64(numSeeders+numLeechers))
+	interval = time.Duration(float64(p.Min) * (1 + math.Log2(n)))
+	if numSeeders == 0 {
+		interval /= 2
+	} else if numLeechers == 0 {
+		interval *= 2
+	}
+	if rate > p.MaxAnnounceRate {
+		interval = time.Duration(float64(interval) * rate / p.MaxAnnounceRate)
+	}
+	interval = clampDuration(interval, p.Min, p.Max)
+	minInterval = clampDuration(interval/2, p.Min, p.Max)
+	return interval.Round(time.Second), minInterval.Round(time.Second)
+}
+
+func clampDuration(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
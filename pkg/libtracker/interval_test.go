@@ -0,0 +1,29 @@
+package libtracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnounceInterval(t *testing.T) {
+	interval := func(seeders, leechers int, rate float64) [2]time.Duration {
+		i, m := announceInterval(seeders, leechers, rate)
+		return [2]time.Duration{i, m}
+	}
+	// 小 swarm 使用最小值，快速收敛
+	assert.Equal(t, [2]time.Duration{15 * time.Second, 15 * time.Second}, interval(0, 1, 0))
+	assert.Equal(t, [2]time.Duration{30 * time.Second, 15 * time.Second}, interval(1, 1, 0))
+	// 随 swarm 大小对数增长
+	assert.Equal(t, [2]time.Duration{165 * time.Second, 83 * time.Second}, interval(24, 1000, 0))
+	assert.Equal(t, [2]time.Duration{330 * time.Second, 165 * time.Second}, interval(1024, 0, 0))
+	// 负载超过上限时按比例延长，不超过 Max
+	assert.Equal(t, [2]time.Duration{330 * time.Second, 165 * time.Second}, interval(24, 1000, 1000))
+	assert.Equal(t, [2]time.Duration{30 * time.Minute, 15 * time.Minute}, interval(24, 1000, 1e6))
+
+	SetIntervalPolicy(IntervalPolicy{Min: time.Minute, Max: 2 * time.Minute})
+	defer SetIntervalPolicy(IntervalPolicy{})
+	assert.Equal(t, [2]time.Duration{time.Minute, time.Minute}, interval(0, 1, 0))
+	assert.Equal(t, [2]time.Duration{2 * time.Minute, time.Minute}, interval(24, 1000, 0))
+}
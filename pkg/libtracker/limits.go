@@ -0,0 +1,274 @@
+package libtracker
+
+import (
+	"crypto/sha1"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/juju/ratelimit"
+)
+
+const (
+	defaultRequestRate      = 20
+	defaultMaxSwarmsPerRoom = 10000
+	defaultMaxPeersPerRoom  = 100000
+	defaultMaxSwarms        = 100000
+	defaultMaxPeers         = 1000000
+	defaultMaxRequestBytes  = 8 << 10
+	// minIntervalSlack 允许 announce 早于 min interval 的时间，客户端从发送请求时开始计时
+	minIntervalSlack = 2 * time.Second
+	// limiterIdle 是删除不活跃 IP 的限速器的时间
+	limiterIdle = time.Minute
+	// maxZoneLength 是 zone 参数的最大长度
+	maxZoneLength = 64
+)
+
+var (
+	errRateLimited      = errors.New("rate limited")
+	errRequestTooLarge  = errors.New("request too large")
+	errTooManySwarms    = errors.New("too many torrents in room")
+	errTooManyPeers     = errors.New("too many peers in room")
+	errTrackerFull      = errors.New("tracker is full")
+	errAnnounceTooOften = errors.New("announce faster than min interval")
+	errInvalidInfoHash  = errors.New("invalid info_hash")
+	errInvalidPeerID    = errors.New("invalid peer_id")
+	errInvalidZone      = errors.New("invalid zone")
+	errInvalidRequest   = errors.New("invalid request")
+)
+
+// Limits protects tracker from misbehaving clients. Zero values use defaults, negative values disable the limit.
+type Limits struct {
+	// 每个 IP（IPv6 为 /64）每秒的 announce、scrape 和 report 请求数，0 表示 20
+	RequestRate float64
+	// 每个 IP 可以突发的请求数，0 表示 RequestRate 的 2 倍
+	RequestBurst int64
+	// 每个 room 的 torrent 数量，0 表示 10000
+	MaxSwarmsPerRoom int
+	// 每个 room 的 peer 数量，同时限制每个 room 的下载记录数量，0 表示 100000
+	MaxPeersPerRoom int
+	// 所有 room 的 torrent 数量，0 表示 100000
+	MaxSwarms int
+	// 所有 room 的 peer 数量，同时限制所有 room 的下载记录数量，0 表示 1000000
+	MaxPeers int
+	// 请求的 query 的最大长度，0 表示 8KB
+	MaxRequestBytes int
+	// 允许没有事件的 announce 早于上次返回的 min interval
+	AllowFastAnnounce bool
+}
+
+var limits = struct {
+	sync.RWMutex
+	Limits
+}{}
+
+// SetLimits sets the limits of tracker, room limits apply to every room.
+func SetLimits(l Limits) {
+	limits.Lock()
+	limits.Limits = l
+	limits.Unlock()
+	limiters.Lock()
+	limiters.m = make(map[string]*limiter)
+	limiters.Unlock()
+}
+
+func getLimits() Limits {
+	limits.RLock()
+	l := limits.Limits
+	limits.RUnlock()
+	if l.RequestRate == 0 {
+		l.RequestRate = defaultRequestRate
+	}
+	if l.RequestBurst == 0 {
+		l.RequestBurst = int64(2 * l.RequestRate)
+	}
+	if l.RequestBurst < 1 {
+		l.RequestBurst = 1
+	}
+	if l.MaxSwarmsPerRoom == 0 {
+		l.MaxSwarmsPerRoom = defaultMaxSwarmsPerRoom
+	}
+	if l.MaxPeersPerRoom == 0 {
+		l.MaxPeersPerRoom = defaultMaxPeersPerRoom
+	}
+	if l.MaxSwarms == 0 {
+		l.MaxSwarms = defaultMaxSwarms
+	}
+	if l.MaxPeers == 0 {
+		l.MaxPeers = defaultMaxPeers
+	}
+	if l.MaxRequestBytes == 0 {
+		l.MaxRequestBytes = defaultMaxRequestBytes
+	}
+	return l
+}
+
+type limiter struct {
+	bucket   *ratelimit.Bucket
+	lastUsed int64
+}
+
+var limiters = struct {
+	sync.Mutex
+	m map[string]*limiter
+}{m: make(map[string]*limiter)}
+
+// limiterKey 返回限速的 key，IPv6 按 /64 限速，同一主机通常分配整个 /64
+func limiterKey(ip net.IP) string {
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String()
+	}
+	return ip.String()
+}
+
+func allowRequest(ip net.IP, l Limits) bool {
+	if l.RequestRate < 0 {
+		return true
+	}
+	key := limiterKey(ip)
+	limiters.Lock()
+	defer limiters.Unlock()
+	lim, ok := limiters.m[key]
+	if !ok {
+		lim = &limiter{bucket: ratelimit.NewBucketWithRate(l.RequestRate, l.RequestBurst)}
+		limiters.m[key] = lim
+	}
+	lim.lastUsed = time.Now().Unix()
+	return lim.bucket.TakeAvailable(1) == 1
+}
+
+// cleanupLimiters 删除不活跃 IP 的限速器，限速器的令牌已经补满，删除不影响限速
+func cleanupLimiters() {
+	expiration := time.Now().Add(-limiterIdle).Unix()
+	limiters.Lock()
+	for key, lim := range limiters.m {
+		if lim.lastUsed < expiration {
+			delete(limiters.m, key)
+		}
+	}
+	limiters.Unlock()
+}
+
+// limitRequest 限制请求的大小和每个 IP 的请求速率，announce 和 scrape 返回 bencode 编码的 failure reason，其他接口返回 JSON
+func limitRequest(bencoded bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reject := func(status int, err error) {
+			if bencoded {
+				failure(c, err.Error())
+			} else {
+				c.JSON(status, gin.H{"error": err.Error()})
+			}
+			c.Abort()
+		}
+		l := getLimits()
+		if l.MaxRequestBytes > 0 && len(c.Request.URL.RawQuery) > l.MaxRequestBytes {
+			reject(http.StatusRequestURITooLong, errRequestTooLarge)
+			return
+		}
+		ip, err := peerIP(c, c.Param("room"), "")
+		if err != nil {
+			reject(http.StatusBadRequest, err)
+			return
+		}
+		if !allowRequest(net.ParseIP(ip), l) {
+			reject(http.StatusTooManyRequests, errRateLimited)
+			return
+		}
+	}
+}
+
+// quota 统计 swarm、peer 和下载记录的数量，在 shard 的写锁中更新
+type quota struct {
+	swarms  int
+	peers   int
+	records int
+}
+
+func (q *quota) add(swarms, peers, records int) {
+	q.swarms += swarms
+	q.peers += peers
+	q.records += records
+}
+
+// quotas 统计每个 room 和整个 tracker 的数量。room 由 announce 创建，不需要认证，
+// 所以 room 的计数归零时删除，整个 tracker 的数量由 MaxSwarms 和 MaxPeers 限制
+var quotas = struct {
+	sync.Mutex
+	rooms map[string]*quota
+	total quota
+}{rooms: make(map[string]*quota)}
+
+// reserve 在 room 中增加 swarm、peer 或下载记录，超过 room 或 tracker 的限制时返回错误，不增加任何数量
+func reserve(room string, swarms, peers, records int) error {
+	l := getLimits()
+	quotas.Lock()
+	defer quotas.Unlock()
+	rq := quotas.rooms[room]
+	if rq == nil {
+		rq = &quota{}
+	}
+	over := func(n, add, max int) bool {
+		return add > 0 && max > 0 && n+add > max
+	}
+	switch {
+	case over(quotas.total.swarms, swarms, l.MaxSwarms),
+		over(quotas.total.peers, peers, l.MaxPeers),
+		over(quotas.total.records, records, l.MaxPeers):
+		return errTrackerFull
+	case over(rq.swarms, swarms, l.MaxSwarmsPerRoom):
+		return errTooManySwarms
+	case over(rq.peers, peers, l.MaxPeersPerRoom), over(rq.records, records, l.MaxPeersPerRoom):
+		return errTooManyPeers
+	}
+	rq.add(swarms, peers, records)
+	quotas.rooms[room] = rq
+	quotas.total.add(swarms, peers, records)
+	return nil
+}
+
+// release 减少 room 中 swarm、peer 和下载记录的数量，room 的计数归零时删除
+func release(room string, swarms, peers, records int) {
+	if swarms == 0 && peers == 0 && records == 0 {
+		return
+	}
+	quotas.Lock()
+	defer quotas.Unlock()
+	if rq, ok := quotas.rooms[room]; ok {
+		rq.add(-swarms, -peers, -records)
+		if *rq == (quota{}) {
+			delete(quotas.rooms, room)
+		}
+	}
+	quotas.total.add(-swarms, -peers, -records)
+}
+
+// announceTooEarly 返回 peer 是否在上次返回的 min interval 之前再次 announce，新的 peer 不受限制
+func announceTooEarly(room, infoHash, ip string, port uint16, now int64) bool {
+	h := sha1.Sum([]byte(room + infoHash))
+	shard := shards[shardIndex(h)]
+	shard.RLock()
+	defer shard.RUnlock()
+	client := serialize(ip, port)
+	p, ok := shard.swarms[h].seeders[client]
+	if !ok {
+		p, ok = shard.swarms[h].leechers[client]
+	}
+	return ok && p.notBefore > now
+}
+
+// validateAnnounce 校验 announce 参数的长度，避免保存过大的数据
+func validateAnnounce(req *AnnounceRequest) error {
+	if len(req.InfoHash) != 20 {
+		return errInvalidInfoHash
+	}
+	if len(req.PeerID) > 20 {
+		return errInvalidPeerID
+	}
+	if len(req.Zone) > maxZoneLength {
+		return errInvalidZone
+	}
+	return nil
+}
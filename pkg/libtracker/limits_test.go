@@ -0,0 +1,168 @@
+package libtracker
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/:room/announce", limitRequest(true), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/:room/report", limitRequest(false), func(c *gin.Context) { c.Status(http.StatusOK) })
+	SetLimits(Limits{RequestRate: 1, RequestBurst: 2, MaxRequestBytes: 100})
+	defer SetLimits(Limits{})
+
+	client := withRemoteAddr("10.0.0.1:1234")
+	w := serveRequest(r, http.MethodGet, "/room/announce?key="+strings.Repeat("k", 100), client)
+	assert.Equal(t, "request too large", failureReason(t, w))
+	assert.Empty(t, serveRequest(r, http.MethodGet, "/room/announce", client).Body.String())
+	assert.Empty(t, serveRequest(r, http.MethodGet, "/room/announce", client).Body.String())
+	assert.Equal(t, "rate limited", failureReason(t, serveRequest(r, http.MethodGet, "/room/announce", client)))
+	assert.Equal(t, http.StatusTooManyRequests, serveRequest(r, http.MethodGet, "/room/report", client).Code)
+	assert.Equal(t, http.StatusOK, serveRequest(r, http.MethodGet, "/room/report", withRemoteAddr("10.0.0.2:1234")).Code)
+	// IPv6 按 /64 限速
+	assert.Equal(t, http.StatusOK, serveRequest(r, http.MethodGet, "/room/report", withRemoteAddr("[fd00::1]:1234")).Code)
+	assert.Equal(t, http.StatusOK, serveRequest(r, http.MethodGet, "/room/report", withRemoteAddr("[fd00::2]:1234")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveRequest(r, http.MethodGet, "/room/report", withRemoteAddr("[fd00::3]:1234")).Code)
+	assert.Equal(t, http.StatusOK, serveRequest(r, http.MethodGet, "/room/report", withRemoteAddr("[fd00:0:0:1::1]:1234")).Code)
+
+	SetLimits(Limits{RequestRate: -1})
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, http.StatusOK, serveRequest(r, http.MethodGet, "/room/report", client).Code)
+	}
+}
+
+func TestLimitAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := newRouter()
+	SetLimits(Limits{RequestRate: 1, RequestBurst: 2})
+	defer SetLimits(Limits{})
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+	client, token := withRemoteAddr("10.0.2.1:1234"), withToken("guess")
+	// 猜测 token 的请求同样受限流限制
+	assert.Equal(t, http.StatusUnauthorized, serveRequest(r, http.MethodGet, "/admin/stats", client, token).Code)
+	assert.Equal(t, http.StatusOK, serveRequest(r, http.MethodGet, "/admin/", client, token).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveRequest(r, http.MethodGet, "/admin/stats", client, token).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveRequest(r, http.MethodGet, "/admin/", client, token).Code)
+}
+
+func TestLimiterKey(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", limiterKey(net.ParseIP("10.0.0.1")))
+	assert.Equal(t, "10.0.0.1", limiterKey(net.ParseIP("::ffff:10.0.0.1")))
+	assert.Equal(t, "2001:db8:1:2::", limiterKey(net.ParseIP("2001:db8:1:2:3:4:5:6")))
+}
+
+func TestRoomLimits(t *testing.T) {
+	SetLimits(Limits{MaxSwarmsPerRoom: 1, MaxPeersPerRoom: 2})
+	defer SetLimits(Limits{})
+	ih1, ih2 := strings.Repeat("j", 20), strings.Repeat("k", 20)
+
+	assert.NoError(t, PutPeer("limits", ih1, "", "", "10.0.0.1", 6881, true))
+	assert.NoError(t, PutPeer("limits", ih1, "", "", "10.0.0.2", 6881, false))
+	assert.Equal(t, errTooManyPeers, PutPeer("limits", ih1, "", "", "10.0.0.3", 6881, false))
+	// 已有的 peer 可以继续 announce
+	assert.NoError(t, GraduateLeecher("limits", ih1, "", "", "10.0.0.2", 6881))
+	assert.Equal(t, errTooManySwarms, PutPeer("limits", ih2, "", "", "10.0.0.1", 6881, true))
+	assert.Equal(t, errTooManySwarms, RecordAnnounce("limits", &AnnounceRequest{InfoHash: ih2, IP: "10.0.0.1", Port: 6881}))
+	// 其他 room 不受影响
+	assert.NoError(t, PutPeer("limits-other", ih2, "", "", "10.0.0.1", 6881, true))
+
+	DeletePeer("limits", ih1, "10.0.0.1", 6881)
+	assert.NoError(t, PutPeer("limits", ih1, "", "", "10.0.0.3", 6881, false))
+	assert.True(t, EvictPeer("limits", ih1, "10.0.0.3", 6881))
+	assert.NoError(t, RecordAnnounce("limits", &AnnounceRequest{InfoHash: ih1, PeerID: "a", IP: "10.0.0.1", Port: 6881}))
+	assert.NoError(t, RecordAnnounce("limits", &AnnounceRequest{InfoHash: ih1, PeerID: "b", IP: "10.0.0.2", Port: 6881}))
+	assert.Equal(t, errTooManyPeers, RecordAnnounce("limits", &AnnounceRequest{InfoHash: ih1, PeerID: "c", IP: "10.0.0.3", Port: 6881}))
+
+	assert.True(t, DeleteSwarm("limits", ih1))
+	assert.NoError(t, PutPeer("limits", ih2, "", "", "10.0.0.1", 6881, true))
+	assert.NoError(t, PutPeer("limits", ih2, "", "", "10.0.0.2", 6881, true))
+
+	// room 的计数归零后删除
+	assert.True(t, DeleteSwarm("limits", ih2))
+	assert.True(t, DeleteSwarm("limits-other", ih2))
+	quotas.Lock()
+	assert.NotContains(t, quotas.rooms, "limits")
+	assert.NotContains(t, quotas.rooms, "limits-other")
+	quotas.Unlock()
+}
+
+func TestTrackerLimits(t *testing.T) {
+	quotas.Lock()
+	total := quotas.total
+	quotas.Unlock()
+	SetLimits(Limits{MaxSwarms: total.swarms + 1, MaxPeers: total.peers + 1})
+	defer SetLimits(Limits{})
+	ih1, ih2 := strings.Repeat("m", 20), strings.Repeat("n", 20)
+
+	assert.NoError(t, PutPeer("full-1", ih1, "", "", "10.0.0.1", 6881, true))
+	// 每个 room 只有一个 torrent，但 tracker 已满
+	assert.Equal(t, errTrackerFull, PutPeer("full-2", ih2, "", "", "10.0.0.1", 6881, true))
+	assert.Equal(t, errTrackerFull, PutPeer("full-1", ih1, "", "", "10.0.0.2", 6881, true))
+	DeletePeer("full-1", ih1, "10.0.0.1", 6881)
+	assert.NoError(t, PutPeer("full-1", ih1, "", "", "10.0.0.2", 6881, true))
+	assert.True(t, DeleteSwarm("full-1", ih1))
+	assert.NoError(t, PutPeer("full-2", ih2, "", "", "10.0.0.1", 6881, true))
+	assert.True(t, DeleteSwarm("full-2", ih2))
+	quotas.Lock()
+	assert.Equal(t, total, quotas.total)
+	quotas.Unlock()
+}
+
+// 超过 peer 数量限制的 announce 不创建下载记录
+func TestAnnounceQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/:room/announce", announce)
+	SetLimits(Limits{MaxPeersPerRoom: 1})
+	defer SetLimits(Limits{})
+	infoHash := strings.Repeat("o", 20)
+
+	path := "/announce-quota/announce?info_hash=" + url.QueryEscape(infoHash) + "&left=1&event=started&port="
+	assert.Nil(t, failureReason(t, serveRequest(r, http.MethodGet, path+"6881")))
+	assert.Equal(t, errTooManyPeers.Error(), failureReason(t, serveRequest(r, http.MethodGet, path+"6882")))
+	if report := GetReport("announce-quota", infoHash); assert.Len(t, report.Torrents, 1) {
+		assert.Len(t, report.Torrents[0].Peers, 1)
+	}
+}
+
+func TestAnnounceLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/:room/announce", announce)
+	infoHash := strings.Repeat("l", 20)
+
+	path := "/announce-limits/announce?port=6881&left=1&"
+	q := "info_hash=" + url.QueryEscape(infoHash)
+	for _, c := range []struct {
+		query  string
+		reason interface{}
+	}{
+		{"info_hash=short", "invalid info_hash"},
+		{q + "&peer_id=" + strings.Repeat("p", 21), "invalid peer_id"},
+		{q + "&numwant=abc", "invalid request"},
+		{q + "&event=started", nil},
+		{q, "announce faster than min interval"},
+		// 事件不受 min interval 限制
+		{q + "&event=completed", nil},
+		{q + "&event=stopped", nil},
+		// stopped 之后重新加入
+		{q, nil},
+	} {
+		w := serveRequest(r, http.MethodGet, path+c.query)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, c.reason, failureReason(t, w), c.query)
+	}
+
+	SetLimits(Limits{AllowFastAnnounce: true})
+	defer SetLimits(Limits{})
+	assert.Nil(t, failureReason(t, serveRequest(r, http.MethodGet, path+q)))
+}
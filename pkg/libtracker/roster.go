@@ -0,0 +1,122 @@
+package libtracker
+
+import (
+	"crypto/sha1"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 预期节点的状态
+const (
+	HostMissing   = "missing"
+	HostStarted   = "started"
+	HostCompleted = "completed"
+)
+
+// HostStatus 是预期节点的下载状态，根据该节点 IP 的 announce 记录判断
+type HostStatus struct {
+	// 主机名或 IP
+	Host string   `json:"host"`
+	IPs  []string `json:"ips"`
+	// missing、started 或 completed
+	Status    string     `json:"status"`
+	Started   *time.Time `json:"started,omitempty"`
+	Completed *time.Time `json:"completed,omitempty"`
+}
+
+type rosterHost struct {
+	host string
+	ips  []string
+}
+
+var rosters = struct {
+	sync.RWMutex
+	m map[string][]rosterHost
+}{m: make(map[string][]rosterHost)}
+
+// SetRoster sets the expected hosts of room. Hostnames are resolved once, unresolved hosts are always missing.
+func SetRoster(room string, hosts []string) {
+	roster := make([]rosterHost, 0, len(hosts))
+	for _, host := range hosts {
+		h := rosterHost{host: host}
+		if ip := net.ParseIP(host); ip != nil {
+			h.ips = []string{ip.String()}
+		} else {
+			ips, err := net.LookupHost(host)
+			if err != nil {
+				log.Warnf("Failed to resolve expected host %s: %s", host, err)
+			}
+			for _, ip := range ips {
+				h.ips = append(h.ips, net.ParseIP(ip).String())
+			}
+		}
+		roster = append(roster, h)
+	}
+	rosters.Lock()
+	rosters.m[room] = roster
+	rosters.Unlock()
+}
+
+// GetHosts returns the status of expected hosts of room for torrent infoHash, nil if room has no roster.
+// Only the swarm of infoHash is read, serve --expect calls it every second.
+func GetHosts(room, infoHash string) []HostStatus {
+	rosters.RLock()
+	_, ok := rosters.m[room]
+	rosters.RUnlock()
+	if !ok {
+		return nil
+	}
+	h := sha1.Sum([]byte(room + infoHash))
+	shard := shards[shardIndex(h)]
+	shard.RLock()
+	var peers []PeerRecord
+	if sw, ok := shard.swarms[h]; ok {
+		peers = make([]PeerRecord, 0, len(sw.history))
+		for _, r := range sw.history {
+			peers = append(peers, *r)
+		}
+	}
+	shard.RUnlock()
+	return hostStatus(room, peers)
+}
+
+func hostStatus(room string, peers []PeerRecord) []HostStatus {
+	rosters.RLock()
+	roster, ok := rosters.m[room]
+	rosters.RUnlock()
+	if !ok {
+		return nil
+	}
+	// 同一 IP 可能有多条记录，如重启后的节点
+	byIP := make(map[string][]PeerRecord)
+	for _, p := range peers {
+		ip := net.ParseIP(p.IP).String()
+		byIP[ip] = append(byIP[ip], p)
+	}
+	hosts := make([]HostStatus, len(roster))
+	for i, h := range roster {
+		s := HostStatus{Host: h.host, IPs: h.ips, Status: HostMissing}
+		for _, ip := range h.ips {
+			for _, p := range byIP[ip] {
+				if s.Started == nil || p.Started.Before(*s.Started) {
+					started := p.Started
+					s.Started = &started
+				}
+				if p.Completed != nil && (s.Completed == nil || p.Completed.Before(*s.Completed)) {
+					s.Completed = p.Completed
+				}
+			}
+		}
+		switch {
+		case s.Completed != nil:
+			s.Status = HostCompleted
+		case s.Started != nil:
+			s.Status = HostStarted
+		}
+		hosts[i] = s
+	}
+	return hosts
+}
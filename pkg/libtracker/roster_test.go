@@ -0,0 +1,26 @@
+package libtracker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostStatus(t *testing.T) {
+	room := "roster"
+	infoHash := string(make([]byte, 20))
+	SetRoster(room, []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"})
+	RecordAnnounce(room, &AnnounceRequest{InfoHash: infoHash, PeerID: "a", IP: "10.0.1.1", Port: 6881, Left: 100, Event: "started"})
+	RecordAnnounce(room, &AnnounceRequest{InfoHash: infoHash, PeerID: "a", IP: "10.0.1.1", Port: 6881, Event: "completed"})
+	RecordAnnounce(room, &AnnounceRequest{InfoHash: infoHash, PeerID: "b", IP: "10.0.1.2", Port: 6881, Left: 100, Event: "started"})
+
+	hosts := GetHosts(room, infoHash)
+	if assert.Len(t, hosts, 3) {
+		assert.Equal(t, HostCompleted, hosts[0].Status)
+		assert.NotNil(t, hosts[0].Completed)
+		assert.Equal(t, HostStarted, hosts[1].Status)
+		assert.Equal(t, HostMissing, hosts[2].Status)
+		assert.Nil(t, hosts[2].Started)
+	}
+	assert.Nil(t, GetHosts("no-roster", infoHash))
+}
@@ -0,0 +1,126 @@
+package libtracker
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultNumWant 是 announce 没有 numwant 参数时返回的 peer 数量
+	defaultNumWant = 30
+	// defaultMaxNumWant 是没有设置 MaxNumWant 时 numwant 的上限
+	defaultMaxNumWant = 100
+	// defaultSeederRatio 是没有设置 SeederRatio 时 seeder 的比例
+	defaultSeederRatio = 0.5
+)
+
+// PeerSelection controls how many and which peers are returned by announce.
+// Seeders and leechers are sampled randomly, so load is spread evenly across seeders and leechers discover each other.
+type PeerSelection struct {
+	// 返回给 leecher 的 peers 中 seeder 的比例，任意一方不够时由另一方补足，0 表示 0.5
+	SeederRatio float64
+	// numwant 的上限，0 表示 100
+	MaxNumWant uint
+}
+
+var selection = struct {
+	sync.RWMutex
+	PeerSelection
+}{}
+
+// SetPeerSelection sets the peer selection of all rooms.
+func SetPeerSelection(s PeerSelection) {
+	selection.Lock()
+	selection.PeerSelection = s
+	selection.Unlock()
+}
+
+// rand.Rand 不是并发安全的
+var rng = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func shufflePeers(peers []Peer) {
+	rng.Lock()
+	rng.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	rng.Unlock()
+}
+
+// xorshift 是每次 GetPeers 使用的随机数生成器，在 shard 的读锁内抽样时不需要竞争 rng 的锁
+type xorshift uint64
+
+func newXorshift() xorshift {
+	rng.Lock()
+	x := xorshift(rng.Uint64())
+	rng.Unlock()
+	if x == 0 {
+		x = 1
+	}
+	return x
+}
+
+func (x *xorshift) intn(n int) int {
+	*x ^= *x << 13
+	*x ^= *x >> 7
+	*x ^= *x << 17
+	return int(uint64(*x) % uint64(n))
+}
+
+// reservoir 从遍历到的 peer 中等概率抽取最多 size 个（reservoir sampling），不需要复制 swarm 中所有的 peer
+type reservoir struct {
+	peers []Peer
+	size  int
+	seen  int
+}
+
+func (r *reservoir) add(addr serializedPeer, p peer, x *xorshift) {
+	r.seen++
+	if len(r.peers) < r.size {
+		r.peers = append(r.peers, addr.deserialize(p))
+	} else if i := x.intn(r.seen); i < r.size {
+		r.peers[i] = addr.deserialize(p)
+	}
+}
+
+// numWant 返回限制在 MaxNumWant 内的 numwant，0 时返回默认值
+func numWant(n uint) uint {
+	selection.RLock()
+	max := selection.MaxNumWant
+	selection.RUnlock()
+	if max == 0 {
+		max = defaultMaxNumWant
+	}
+	if n == 0 {
+		n = defaultNumWant
+	}
+	if n > max {
+		n = max
+	}
+	return n
+}
+
+// mixPeers 随机打乱 seeders 和 leechers（GetPeers 抽取的样本）后按 SeederRatio 交替合并，结果的任意前缀中 seeder 的比例都接近 SeederRatio
+func mixPeers(seeders, leechers []Peer) []Peer {
+	selection.RLock()
+	ratio := selection.SeederRatio
+	selection.RUnlock()
+	if ratio <= 0 || ratio > 1 {
+		ratio = defaultSeederRatio
+	}
+	shufflePeers(seeders)
+	shufflePeers(leechers)
+	peers := make([]Peer, 0, len(seeders)+len(leechers))
+	var si, li int
+	for i := 0; i < cap(peers); i++ {
+		if si < len(seeders) && (li >= len(leechers) || float64(si) < ratio*float64(i+1)) {
+			peers = append(peers, seeders[si])
+			si++
+		} else {
+			peers = append(peers, leechers[li])
+			li++
+		}
+	}
+	return peers
+}
@@ -0,0 +1,70 @@
+package libtracker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumWant(t *testing.T) {
+	assert.Equal(t, uint(defaultNumWant), numWant(0))
+	assert.Equal(t, uint(50), numWant(50))
+	assert.Equal(t, uint(defaultMaxNumWant), numWant(1000))
+	SetPeerSelection(PeerSelection{MaxNumWant: 10})
+	defer SetPeerSelection(PeerSelection{})
+	assert.Equal(t, uint(10), numWant(0))
+}
+
+// 大量 leecher announce 时每个 seeder 被返回的次数接近平均值
+func TestGetPeersEvenLoad(t *testing.T) {
+	infoHash := strings.Repeat("f", 20)
+	for i := 0; i < 20; i++ {
+		PutPeer("load", infoHash, "", "", fmt.Sprintf("10.0.0.%d", i), 6881, true)
+	}
+	count := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		peers, _, _ := GetPeers("load", infoHash, "10.1.0.1", uint16(i), "", false, 5)
+		assert.Len(t, peers, 5)
+		for _, p := range peers {
+			count[p.IP.String()]++
+		}
+	}
+	assert.Len(t, count, 20)
+	// 平均 500 次
+	for ip, n := range count {
+		assert.InDelta(t, 500, n, 100, ip)
+	}
+}
+
+func TestGetPeersSeederRatio(t *testing.T) {
+	infoHash := strings.Repeat("g", 20)
+	for i := 0; i < 50; i++ {
+		PutPeer("ratio", infoHash, "", "", fmt.Sprintf("10.0.0.%d", i), 6881, true)
+		PutPeer("ratio", infoHash, "", "", fmt.Sprintf("10.0.1.%d", i), 6881, false)
+	}
+	seeders := func(peers []Peer) int {
+		n := 0
+		for _, p := range peers {
+			if p.IP[14] == 0 {
+				n++
+			}
+		}
+		return n
+	}
+	peers, _, _ := GetPeers("ratio", infoHash, "10.1.0.1", 6881, "", false, 10)
+	assert.Equal(t, 5, seeders(peers))
+
+	SetPeerSelection(PeerSelection{SeederRatio: 0.2})
+	defer SetPeerSelection(PeerSelection{})
+	peers, _, _ = GetPeers("ratio", infoHash, "10.1.0.1", 6881, "", false, 10)
+	assert.Equal(t, 2, seeders(peers))
+	// leecher 不够时由 seeder 补足
+	peers, _, _ = GetPeers("ratio", infoHash, "10.1.0.1", 6881, "", false, 90)
+	assert.Equal(t, 40, seeders(peers))
+	// seeder 只返回 leecher
+	peers, _, _ = GetPeers("ratio", infoHash, "10.0.0.1", 6881, "", true, 10)
+	assert.Equal(t, 0, seeders(peers))
+	assert.Len(t, peers, 10)
+}
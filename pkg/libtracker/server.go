@@ -4,19 +4,62 @@
 package libtracker
 
 import (
+	"encoding/hex"
+	"net/http"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/jackpal/bencode-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxHeaderBytes 包括请求行，query 的长度另见 Limits.MaxRequestBytes
+	maxHeaderBytes    = 64 << 10
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 2 * time.Minute
 )
 
+// RunTrackerServer 启动 tracker，addr 不指定 host 时同时监听 IPv4 和 IPv6
 func RunTrackerServer(addr string, debug bool) {
 	if !debug {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	r := gin.Default()
-	r.GET("/:room/announce", announce)
-	r.GET("/:room/scrape", scrape)
 	go Cleanup()
-	r.Run(addr)
+	go MeasureLoad()
+	// 限制请求头的大小和读取时间，避免慢速客户端占用连接
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           newRouter(),
+		MaxHeaderBytes:    maxHeaderBytes,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		log.Errorf("Tracker server stopped: %v", err)
+	}
+}
+
+// newRouter 返回 tracker 的所有路由，admin API 和管理页面同样按客户端 IP 限流，避免暴力猜测 token
+func newRouter() *gin.Engine {
+	r := gin.Default()
+	// 访问日志中的地址不使用 X-Forwarded-For，peer 的地址见 peerIP
+	r.SetTrustedProxies(nil)
+	r.GET("/:room/announce", limitRequest(true), announce)
+	r.GET("/:room/scrape", limitRequest(true), scrape)
+	r.GET("/:room/report", limitRequest(false), report)
+	admin := r.Group("/admin", limitRequest(false), adminAuth)
+	admin.GET("/allowlist", listAllowlist)
+	admin.PUT("/allowlist/:room/:info_hash", updateAllowlist)
+	admin.DELETE("/allowlist/:room/:info_hash", updateAllowlist)
+	admin.GET("/stats", adminStats)
+	admin.GET("/rooms", adminRooms)
+	admin.GET("/swarms", adminSwarms)
+	admin.GET("/swarms/:room/:info_hash", adminSwarm)
+	admin.DELETE("/swarms/:room/:info_hash", adminDeleteSwarm)
+	admin.DELETE("/swarms/:room/:info_hash/peers/:addr", adminEvictPeer)
+	r.GET("/admin/", limitRequest(false), dashboard)
+	return r
 }
 
 type AnnounceRequest struct {
@@ -29,53 +72,132 @@ type AnnounceRequest struct {
 	Left          uint   `form:"left"`
 	Numwant       uint   `form:"numwant"`
 	Key           string `form:"key"`
-	Compact       bool   `form:"compact"`
+	Compact       string `form:"compact"`
+	NoPeerID      bool   `form:"no_peer_id"`
 	SupportCrypto bool   `form:"supportcrypto"`
 	Event         string `form:"event"`
+	// peer 上报的 zone，优先于 Topology 中的 CIDR
+	Zone string `form:"zone"`
 }
 
 func (req *AnnounceRequest) IsSeeding() bool {
 	return req.Left == 0
 }
 
+// IsCompact 没有 compact 参数时默认返回 compact 格式
+func (req *AnnounceRequest) IsCompact() bool {
+	return req.Compact != "0"
+}
+
 type AnnounceResponse struct {
-	Interval   int    `bencode:"interval"`
-	Complete   int    `bencode:"complete"`
-	Incomplete int    `bencode:"incomplete"`
-	Peers      []byte `bencode:"peers"`
-	PeersIPv6  []byte `bencode:"peers_ipv6"`
+	Interval    int `bencode:"interval"`
+	MinInterval int `bencode:"min interval"`
+	Complete    int `bencode:"complete"`
+	Incomplete  int `bencode:"incomplete"`
+	// compact 格式为 []byte，每个 IPv4 peer 为 4 字节地址和 2 字节端口；否则为 []DictPeer，包括 IPv6 peer
+	Peers interface{} `bencode:"peers"`
+	// BEP 7: 每个 IPv6 peer 为 16 字节地址和 2 字节端口，只用于 compact 格式
+	PeersIPv6 []byte `bencode:"peers6,omitempty"`
+}
+
+// DictPeer is a peer in non-compact announce response.
+type DictPeer struct {
+	PeerID string `bencode:"peer id,omitempty"`
+	IP     string `bencode:"ip"`
+	Port   uint16 `bencode:"port"`
+}
+
+func compactPeers(peers []Peer) (peersIPv4, peersIPv6 []byte) {
+	peersIPv4 = []byte{}
+	for _, p := range peers {
+		if ip := p.IP.To4(); ip != nil {
+			peersIPv4 = append(peersIPv4, ip...)
+			peersIPv4 = append(peersIPv4, byte(p.Port>>8), byte(p.Port))
+		} else {
+			peersIPv6 = append(peersIPv6, p.IP.To16()...)
+			peersIPv6 = append(peersIPv6, byte(p.Port>>8), byte(p.Port))
+		}
+	}
+	return
+}
+
+func dictPeers(peers []Peer, noPeerID bool) []DictPeer {
+	list := make([]DictPeer, 0, len(peers))
+	for _, p := range peers {
+		d := DictPeer{IP: p.IP.String(), Port: p.Port}
+		if !noPeerID {
+			d.PeerID = p.PeerID
+		}
+		list = append(list, d)
+	}
+	return list
 }
 
 func announce(c *gin.Context) {
 	req := new(AnnounceRequest)
-	c.BindQuery(req)
-	// if req.IP == "" {
-	req.IP = c.ClientIP() // not sure if ip from request should be honored
-	// }
-	if req.Numwant == 0 {
-		req.Numwant = 30
+	if err := c.ShouldBindQuery(req); err != nil {
+		failure(c, errInvalidRequest.Error())
+		return
+	}
+	if err := validateAnnounce(req); err != nil {
+		failure(c, err.Error())
+		return
+	}
+	if err := authorize(c.Param("room"), req.InfoHash, c.Query("token")); err != nil {
+		failure(c, err.Error())
+		return
 	}
+	if err := allowed(c.Param("room"), req.InfoHash); err != nil {
+		failure(c, err.Error())
+		return
+	}
+	ip, err := peerIP(c, c.Param("room"), req.IP)
+	if err != nil {
+		failure(c, err.Error())
+		return
+	}
+	req.IP = ip
+	if req.Zone == "" {
+		req.Zone = zoneOf(req.IP)
+	}
+	// 只限制定期 announce，started、completed 和 stopped 事件不受 min interval 限制
+	if req.Event == "" && !getLimits().AllowFastAnnounce &&
+		announceTooEarly(c.Param("room"), req.InfoHash, req.IP, req.Port, time.Now().Unix()) {
+		failure(c, errAnnounceTooOften.Error())
+		return
+	}
+	req.Numwant = numWant(req.Numwant)
+	countAnnounce()
+	// 先更新 peer，超过 peer 数量限制的 announce 不创建下载记录
 	switch req.Event {
 	case "stopped":
 		DeletePeer(c.Param("room"), req.InfoHash, req.IP, req.Port)
 	case "completed":
-		GraduateLeecher(c.Param("room"), req.InfoHash, req.IP, req.Port)
+		err = GraduateLeecher(c.Param("room"), req.InfoHash, req.PeerID, req.Zone, req.IP, req.Port)
 	default:
-		PutPeer(c.Param("room"), req.InfoHash, req.IP, req.Port, req.IsSeeding())
+		err = PutPeer(c.Param("room"), req.InfoHash, req.PeerID, req.Zone, req.IP, req.Port, req.IsSeeding())
+	}
+	if err == nil {
+		err = RecordAnnounce(c.Param("room"), req)
 	}
-	peersIPv4, peersIPv6, numSeeders, numLeechers := GetPeers(c.Param("room"), req.InfoHash, req.IP, req.Port, req.IsSeeding(), req.Numwant)
-	interval := 120
-	if numSeeders == 0 {
-		interval /= 2
-	} else if numLeechers == 0 {
-		interval *= 2
+	if err != nil {
+		failure(c, err.Error())
+		return
 	}
+	peers, numSeeders, numLeechers := GetPeers(c.Param("room"), req.InfoHash, req.IP, req.Port, req.Zone, req.IsSeeding(), req.Numwant)
+	interval, minInterval := announceInterval(numSeeders, numLeechers, currentAnnounceRate())
 	resp := AnnounceResponse{
-		Interval:   interval,
-		Complete:   numSeeders,
-		Incomplete: numLeechers,
-		Peers:      peersIPv4,
-		PeersIPv6:  peersIPv6,
+		Interval:    int(interval.Seconds()),
+		MinInterval: int(minInterval.Seconds()),
+		Complete:    numSeeders,
+		Incomplete:  numLeechers,
+	}
+	if req.IsCompact() {
+		peersIPv4, peersIPv6 := compactPeers(peers)
+		resp.Peers = peersIPv4
+		resp.PeersIPv6 = peersIPv6
+	} else {
+		resp.Peers = dictPeers(peers, req.NoPeerID)
 	}
 	if err := bencode.Marshal(c.Writer, resp); err != nil {
 		c.Error(err)
@@ -84,7 +206,8 @@ func announce(c *gin.Context) {
 }
 
 type ScrapeRequest struct {
-	InfoHash string `form:"info_hash"`
+	// 多个 info_hash 参数时返回多个 torrent，没有 info_hash 参数时返回 room 中所有 torrent
+	InfoHashes []string `form:"info_hash"`
 }
 
 type ScrapeResponse struct {
@@ -94,23 +217,67 @@ type ScrapeResponse struct {
 type Stat struct {
 	Complete   int `bencode:"complete"`
 	Incomplete int `bencode:"incomplete"`
-	// Downloaded uint `bencode:"downloaded"`
+	// 完成下载的 peer 数量，见 GetCompleted
+	Downloaded int `bencode:"downloaded"`
 }
 
+// scrape 返回请求的 torrent 的统计，tracker 中不存在或未注册（见 allowlist）的 torrent 不返回。
+// 开启认证时，room 的 token 可以 scrape 所有 torrent，torrent 的 token 只能 scrape 该 torrent
 func scrape(c *gin.Context) {
 	req := new(ScrapeRequest)
-	c.BindQuery(req)
-	numSeeders, numLeechers := GetStats(c.Param("room"), req.InfoHash)
-	resp := ScrapeResponse{
-		Files: map[string]Stat{
-			req.InfoHash: {
-				Complete:   numSeeders,
-				Incomplete: numLeechers,
-			},
-		},
+	if err := c.ShouldBindQuery(req); err != nil {
+		failure(c, errInvalidRequest.Error())
+		return
 	}
-	if err := bencode.Marshal(c.Writer, resp); err != nil {
+	room, token := c.Param("room"), c.Query("token")
+	roomErr := authorize(room, "", token)
+	if len(req.InfoHashes) == 0 && roomErr != nil {
+		failure(c, roomErr.Error())
+		return
+	}
+	var infoHashes []string
+	var err error
+	for _, infoHash := range req.InfoHashes {
+		if len(infoHash) != 20 {
+			failure(c, errInvalidInfoHash.Error())
+			return
+		}
+		if roomErr != nil {
+			if err = authorize(room, infoHash, token); err != nil {
+				failure(c, err.Error())
+				return
+			}
+		}
+		if err = allowed(room, infoHash); err == nil {
+			infoHashes = append(infoHashes, infoHash)
+		}
+	}
+	// 请求的 torrent 都没有注册
+	if len(req.InfoHashes) > 0 && len(infoHashes) == 0 {
+		failure(c, err.Error())
+		return
+	}
+	files := GetScrape(room, infoHashes)
+	for infoHash := range files {
+		if allowed(room, infoHash) != nil {
+			delete(files, infoHash)
+		}
+	}
+	if err := bencode.Marshal(c.Writer, ScrapeResponse{Files: files}); err != nil {
 		c.Error(err)
 		return
 	}
 }
+
+// report 返回 room 中所有 torrent 的下载记录，info_hash 参数（hex 编码或原始的 20 字节）只返回一个 torrent
+func report(c *gin.Context) {
+	infoHash := c.Query("info_hash")
+	if b, err := hex.DecodeString(infoHash); err == nil && len(b) == 20 {
+		infoHash = string(b)
+	}
+	if err := authorize(c.Param("room"), infoHash, c.Query("token")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GetReport(c.Param("room"), infoHash))
+}
@@ -0,0 +1,88 @@
+package libtracker
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnouncePeerFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/:room/announce", announce)
+	infoHash := strings.Repeat("d", 20)
+	peerID := strings.Repeat("p", 20)
+	PutPeer("format", infoHash, peerID, "", "10.0.0.1", 6881, true)
+	PutPeer("format", infoHash, "", "", "fd00::1", 6882, true)
+
+	// started 事件不受 min interval 限制
+	path := "/format/announce?info_hash=" + url.QueryEscape(infoHash) + "&port=7000&left=1&event=started"
+
+	// 默认为 compact 格式
+	for _, query := range []string{"", "&compact=1"} {
+		resp := decodeResponse(t, serveRequest(r, http.MethodGet, path+query))
+		assert.Len(t, resp["peers"], 6)
+		assert.Len(t, resp["peers6"], 18)
+	}
+
+	resp := decodeResponse(t, serveRequest(r, http.MethodGet, path+"&compact=0"))
+	assert.NotContains(t, resp, "peers6")
+	assert.ElementsMatch(t, []interface{}{
+		map[string]interface{}{"peer id": peerID, "ip": "10.0.0.1", "port": int64(6881)},
+		map[string]interface{}{"ip": "fd00::1", "port": int64(6882)},
+	}, resp["peers"])
+
+	resp = decodeResponse(t, serveRequest(r, http.MethodGet, path+"&compact=0&no_peer_id=1"))
+	assert.ElementsMatch(t, []interface{}{
+		map[string]interface{}{"ip": "10.0.0.1", "port": int64(6881)},
+		map[string]interface{}{"ip": "fd00::1", "port": int64(6882)},
+	}, resp["peers"])
+}
+
+func TestScrape(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/:room/scrape", scrape)
+	ih1, ih2, unknown := strings.Repeat("1", 20), strings.Repeat("2", 20), strings.Repeat("3", 20)
+	PutPeer("scrape", ih1, "", "", "10.0.0.1", 6881, true)
+	PutPeer("scrape", ih1, "", "", "10.0.0.2", 6881, false)
+	PutPeer("scrape", ih2, "", "", "10.0.0.1", 6881, true)
+	RecordAnnounce("scrape", &AnnounceRequest{InfoHash: ih2, IP: "10.0.0.3", Port: 6881, Event: "completed"})
+
+	scrapeURL := func(room string, infoHashes ...string) string {
+		return "/" + room + "/scrape?" + url.Values{"info_hash": infoHashes}.Encode()
+	}
+	files := func(room string, infoHashes ...string) interface{} {
+		return decodeResponse(t, serveRequest(r, http.MethodGet, scrapeURL(room, infoHashes...)))["files"]
+	}
+	stat := func(complete, incomplete, downloaded int64) map[string]interface{} {
+		return map[string]interface{}{"complete": complete, "incomplete": incomplete, "downloaded": downloaded}
+	}
+
+	assert.Equal(t, map[string]interface{}{ih1: stat(1, 1, 0)}, files("scrape", ih1))
+	// 不存在的 torrent 不返回
+	assert.Equal(t, map[string]interface{}{ih1: stat(1, 1, 0), ih2: stat(1, 0, 1)}, files("scrape", ih1, ih2, unknown))
+	assert.Equal(t, map[string]interface{}{}, files("scrape", unknown))
+	// full scrape 只返回该 room 的 torrent
+	assert.Equal(t, map[string]interface{}{ih1: stat(1, 1, 0), ih2: stat(1, 0, 1)}, files("scrape"))
+	assert.Equal(t, map[string]interface{}{}, files("scrape-empty"))
+
+	EnableAllowlist()
+	AllowTorrent("scrape", ih1)
+	defer func() {
+		allowlist.Lock()
+		allowlist.enabled = false
+		allowlist.m = make(map[allowKey]bool)
+		allowlist.Unlock()
+	}()
+	assert.Equal(t, map[string]interface{}{ih1: stat(1, 1, 0)}, files("scrape", ih1, ih2))
+	assert.Equal(t, map[string]interface{}{ih1: stat(1, 1, 0)}, files("scrape"))
+	assert.Equal(t, "unregistered torrent", failureReason(t, serveRequest(r, http.MethodGet, scrapeURL("scrape", ih2))))
+
+	SetRoomSecret("scrape-auth", "secret")
+	assert.Equal(t, "missing token", failureReason(t, serveRequest(r, http.MethodGet, scrapeURL("scrape-auth"))))
+}
@@ -1,7 +1,6 @@
 package libtracker
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
 	"net"
@@ -17,13 +16,45 @@ type shard struct {
 	sync.RWMutex
 }
 
+// peer 保存 announce 的 peer_id、zone、最近一次 announce 的时间、过期时间和下次允许定期 announce 的时间
+type peer struct {
+	peerID    string
+	zone      string
+	lastSeen  int64
+	expires   int64
+	notBefore int64
+}
+
+// Peer is a peer returned by GetPeers.
+type Peer struct {
+	// 原始的 20 字节 peer_id，可能为空
+	PeerID string
+	IP     net.IP
+	Port   uint16
+	// peer 所在的 zone，见 Topology
+	Zone string
+}
+
 type swarm struct {
-	seeders  map[serializedPeer]int64
-	leechers map[serializedPeer]int64
+	room     string
+	infoHash string
+	seeders  map[serializedPeer]peer
+	leechers map[serializedPeer]peer
+	// 每个 peer 的下载记录，以 peer_id 为 key，最近一次 announce 超过 ExpiryPolicy.History 后由 Cleanup 删除
+	history map[string]*PeerRecord
+}
+
+func newSwarm(room, infoHash string) swarm {
+	return swarm{
+		room:     room,
+		infoHash: infoHash,
+		seeders:  make(map[serializedPeer]peer),
+		leechers: make(map[serializedPeer]peer),
+		history:  make(map[string]*PeerRecord),
+	}
 }
 
 var shards = NewShards(512)
-var v4InV6Prefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
 
 func shardIndex(hash [20]byte) int {
 	return int(binary.BigEndian.Uint32(hash[:4])) % len(shards)
@@ -43,90 +74,126 @@ func serialize(ip string, port uint16) serializedPeer {
 	return serializedPeer(append(net.ParseIP(ip), byte(port>>8), byte(port)))
 }
 
-func PutPeer(room, infoHash, ip string, port uint16, seeding bool) {
-	h := sha1.Sum([]byte(room + infoHash))
-	shard := shards[shardIndex(h)]
-	shard.Lock()
-	if _, ok := shard.swarms[h]; !ok {
-		shard.swarms[h] = swarm{
-			seeders:  make(map[serializedPeer]int64),
-			leechers: make(map[serializedPeer]int64),
-		}
+func (addr serializedPeer) deserialize(p peer) Peer {
+	return Peer{
+		PeerID: p.peerID,
+		IP:     net.IP(addr[:net.IPv6len]),
+		Port:   binary.BigEndian.Uint16([]byte(addr[net.IPv6len:])),
+		Zone:   p.zone,
 	}
-	client := serialize(ip, port)
+}
+
+// getOrCreate 返回 swarm，不存在时创建，room 或 tracker 的 swarm 数量超过限制时返回错误。需要持有 shard 的写锁
+func (shard *shard) getOrCreate(h hash, room, infoHash string) (swarm, error) {
+	if sw, ok := shard.swarms[h]; ok {
+		return sw, nil
+	}
+	if err := reserve(room, 1, 0, 0); err != nil {
+		return swarm{}, err
+	}
+	sw := newSwarm(room, infoHash)
+	shard.swarms[h] = sw
+	return sw, nil
+}
+
+// put 添加或更新 peer，并从另一个列表中删除，room 或 tracker 的 peer 数量超过限制时返回错误。
+// 过期时间和下次允许定期 announce 的时间根据加入后 swarm 的 announce interval 计算，见 ExpiryPolicy 和 Limits
+func (sw swarm) put(client serializedPeer, peerID, zone string, seeding bool) error {
+	m, other := sw.leechers, sw.seeders
 	if seeding {
-		shard.swarms[h].seeders[client] = time.Now().Unix()
-	} else {
-		shard.swarms[h].leechers[client] = time.Now().Unix()
+		m, other = sw.seeders, sw.leechers
 	}
-	shard.Unlock()
+	if _, ok := other[client]; ok {
+		delete(other, client)
+	} else if _, ok := m[client]; !ok {
+		if err := reserve(sw.room, 0, 1, 0); err != nil {
+			return err
+		}
+	}
+	// 先加入 peer，数量和返回给该 peer 的 interval 一致
+	now := time.Now()
+	m[client] = peer{peerID: peerID, zone: zone}
+	_, minInterval := announceInterval(len(sw.seeders), len(sw.leechers), currentAnnounceRate())
+	m[client] = peer{peerID, zone, now.Unix(), now.Add(peerTTL(len(sw.seeders), len(sw.leechers))).Unix(),
+		now.Add(minInterval - minIntervalSlack).Unix()}
+	return nil
 }
 
-func DeletePeer(room, infoHash, ip string, port uint16) {
+// remove 删除 peer，返回 peer 是否存在
+func (sw swarm) remove(client serializedPeer) bool {
+	_, seeder := sw.seeders[client]
+	_, leecher := sw.leechers[client]
+	delete(sw.seeders, client)
+	delete(sw.leechers, client)
+	if seeder || leecher {
+		release(sw.room, 0, 1, 0)
+	}
+	return seeder || leecher
+}
+
+func PutPeer(room, infoHash, peerID, zone, ip string, port uint16, seeding bool) error {
 	h := sha1.Sum([]byte(room + infoHash))
 	shard := shards[shardIndex(h)]
 	shard.Lock()
-	if _, ok := shard.swarms[h]; !ok {
-		return
+	defer shard.Unlock()
+	sw, err := shard.getOrCreate(h, room, infoHash)
+	if err != nil {
+		return err
 	}
-	client := serialize(ip, port)
-	delete(shard.swarms[h].seeders, client)
-	delete(shard.swarms[h].leechers, client)
-	shard.Unlock()
+	return sw.put(serialize(ip, port), peerID, zone, seeding)
 }
 
-func GraduateLeecher(room, infoHash, ip string, port uint16) {
+func DeletePeer(room, infoHash, ip string, port uint16) {
 	h := sha1.Sum([]byte(room + infoHash))
 	shard := shards[shardIndex(h)]
 	shard.Lock()
-	if _, ok := shard.swarms[h]; !ok {
-		shard.swarms[h] = swarm{
-			seeders:  make(map[serializedPeer]int64),
-			leechers: make(map[serializedPeer]int64),
-		}
+	if sw, ok := shard.swarms[h]; ok {
+		sw.remove(serialize(ip, port))
 	}
-	client := serialize(ip, port)
-	shard.swarms[h].seeders[client] = time.Now().Unix()
-	delete(shard.swarms[h].leechers, client)
 	shard.Unlock()
 }
 
-func GetPeers(room, infoHash, ip string, port uint16, seeding bool, numWant uint) (peersIPv4, peersIPv6 []byte, numSeeders, numLeechers int) {
+func GraduateLeecher(room, infoHash, peerID, zone, ip string, port uint16) error {
+	return PutPeer(room, infoHash, peerID, zone, ip, port, true)
+}
+
+// GetPeers returns at most numWant peers for the client sampled randomly, see PeerSelection.
+// Peers in the same zone are preferred, see Topology.
+func GetPeers(room, infoHash, ip string, port uint16, zone string, seeding bool, numWant uint) (peers []Peer, numSeeders, numLeechers int) {
 	h := sha1.Sum([]byte(room + infoHash))
 	shard := shards[shardIndex(h)]
-	shard.RLock()
 	client := serialize(ip, port)
+	x := newXorshift()
+	// 按 seeder、leecher 和是否同一 zone 分为 4 类，每类最多抽取 numWant 个，足够 mixPeers 和 selectPeers 选择
+	samples := [4]reservoir{}
+	for i := range samples {
+		samples[i].size = int(numWant)
+	}
+	add := func(i int, addr serializedPeer, p peer) {
+		if zone != "" && p.zone != zone {
+			i++
+		}
+		samples[i].add(addr, p, &x)
+	}
+	shard.RLock()
+	sw := shard.swarms[h]
 	// seeders don't need other seeders
 	if !seeding {
-		for peer := range shard.swarms[h].seeders {
-			if numWant == 0 {
-				break
-			}
-			if bytes.HasPrefix([]byte(peer), v4InV6Prefix) {
-				peersIPv4 = append(peersIPv4, peer[12:]...)
-			} else {
-				peersIPv6 = append(peersIPv6, peer...)
-			}
-			numWant--
+		for addr, p := range sw.seeders {
+			add(0, addr, p)
 		}
 	}
-	for peer := range shard.swarms[h].leechers {
-		if peer == client {
-			continue
+	for addr, p := range sw.leechers {
+		if addr != client {
+			add(2, addr, p)
 		}
-		if numWant == 0 {
-			break
-		}
-		if bytes.HasPrefix([]byte(peer), v4InV6Prefix) {
-			peersIPv4 = append(peersIPv4, peer[12:]...)
-		} else {
-			peersIPv6 = append(peersIPv6, peer...)
-		}
-		numWant--
 	}
-	numSeeders = len(shard.swarms[h].seeders)
-	numLeechers = len(shard.swarms[h].leechers)
+	numSeeders = len(sw.seeders)
+	numLeechers = len(sw.leechers)
 	shard.RUnlock()
+	candidates := mixPeers(samples[0].peers, samples[2].peers)
+	candidates = append(candidates, mixPeers(samples[1].peers, samples[3].peers)...)
+	peers = selectPeers(candidates, zone, int(numWant))
 	return
 }
 
@@ -140,28 +207,33 @@ func GetStats(room, infoHash string) (numSeeders, numLeechers int) {
 	return
 }
 
-func Cleanup() {
-	for {
-		expiration := time.Now().Unix() - 600
+// GetScrape returns stats of torrents infoHashes in room, or all torrents in room if infoHashes is empty.
+// Torrents not in tracker are omitted.
+func GetScrape(room string, infoHashes []string) map[string]Stat {
+	files := make(map[string]Stat)
+	stat := func(sw swarm) Stat {
+		return Stat{Complete: len(sw.seeders), Incomplete: len(sw.leechers), Downloaded: countCompleted(sw.history)}
+	}
+	if len(infoHashes) == 0 {
 		for _, shard := range shards {
-			shard.Lock()
-			for h, swarm := range shard.swarms {
-				for peer, lastSeen := range swarm.seeders {
-					if lastSeen < expiration {
-						delete(swarm.seeders, peer)
-					}
-				}
-				for peer, lastSeen := range swarm.leechers {
-					if lastSeen < expiration {
-						delete(swarm.leechers, peer)
-					}
-				}
-				if len(swarm.leechers) == 0 && len(swarm.seeders) == 0 {
-					delete(shard.swarms, h)
+			shard.RLock()
+			for _, sw := range shard.swarms {
+				if sw.room == room {
+					files[sw.infoHash] = stat(sw)
 				}
 			}
-			shard.Unlock()
+			shard.RUnlock()
+		}
+		return files
+	}
+	for _, infoHash := range infoHashes {
+		h := sha1.Sum([]byte(room + infoHash))
+		shard := shards[shardIndex(h)]
+		shard.RLock()
+		if sw, ok := shard.swarms[h]; ok {
+			files[infoHash] = stat(sw)
 		}
-		time.Sleep(time.Minute * 3)
+		shard.RUnlock()
 	}
+	return files
 }
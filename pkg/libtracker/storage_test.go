@@ -0,0 +1,32 @@
+package libtracker
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPeersIPv6(t *testing.T) {
+	infoHash := strings.Repeat("c", 20)
+	PutPeer("ipv6", infoHash, "", "", "10.0.0.1", 6881, true)
+	PutPeer("ipv6", infoHash, "", "", "fd00::1", 6882, true)
+	PutPeer("ipv6", infoHash, "", "", "::ffff:10.0.0.2", 6883, false)
+
+	peers, seeders, leechers := GetPeers("ipv6", infoHash, "fd00::2", 6884, "", false, 30)
+	assert.Equal(t, 2, seeders)
+	assert.Equal(t, 1, leechers)
+	peersIPv4, peersIPv6 := compactPeers(peers)
+	// IPv4 peer 为 6 字节，IPv4-mapped IPv6 地址作为 IPv4 返回
+	assert.Len(t, peersIPv4, 12)
+	assert.ElementsMatch(t, []string{"10.0.0.1:6881", "10.0.0.2:6883"}, []string{
+		compactAddr(peersIPv4[:6]), compactAddr(peersIPv4[6:]),
+	})
+	assert.Equal(t, []byte(net.ParseIP("fd00::1")), peersIPv6[:16])
+	assert.Equal(t, []byte{6882 >> 8, 6882 & 0xff}, peersIPv6[16:])
+}
+
+func compactAddr(b []byte) string {
+	return (&net.TCPAddr{IP: net.IP(b[:len(b)-2]), Port: int(b[len(b)-2])<<8 | int(b[len(b)-1])}).String()
+}
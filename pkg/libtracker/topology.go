@@ -0,0 +1,91 @@
+package libtracker
+
+import (
+	"math"
+	"net"
+	"sync"
+)
+
+// defaultLocalRatio 是没有设置 LocalRatio 时同一 zone 的 peer 的比例
+const defaultLocalRatio = 0.8
+
+// Topology maps peer addresses to zones (subnet, rack, etc.), announce returns peers in the same zone first.
+type Topology struct {
+	// zone 到 CIDR 的映射，地址匹配多个 zone 时使用最长前缀
+	Zones map[string][]*net.IPNet
+	// 同一 zone 的 peer 在返回的 peers 中的最大比例，剩余的为其他 zone 的 peer，保证 zone 之间可以交换数据。
+	// 0 表示 0.8，1 表示只有同一 zone 的 peer 不够时才返回其他 zone 的 peer。
+	// 结果向下取整，但至少有一个同一 zone 的 peer
+	LocalRatio float64
+}
+
+var topology = struct {
+	sync.RWMutex
+	Topology
+}{}
+
+// SetTopology sets zones of peers. Peers may also report their zone in `zone` param of announce,
+// which takes precedence over zones.
+func SetTopology(t Topology) {
+	topology.Lock()
+	topology.Topology = t
+	topology.Unlock()
+}
+
+// zoneOf 返回 ip 所在的 zone，没有匹配时返回空
+func zoneOf(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	topology.RLock()
+	defer topology.RUnlock()
+	zone, longest := "", -1
+	for z, nets := range topology.Zones {
+		for _, n := range nets {
+			if ones, _ := n.Mask.Size(); n.Contains(addr) && ones > longest {
+				zone, longest = z, ones
+			}
+		}
+	}
+	return zone
+}
+
+func localRatio() float64 {
+	topology.RLock()
+	defer topology.RUnlock()
+	if topology.LocalRatio <= 0 || topology.LocalRatio > 1 {
+		return defaultLocalRatio
+	}
+	return topology.LocalRatio
+}
+
+// selectPeers 从 candidates 中按顺序选择 numWant 个 peer，zone 不为空时优先选择同一 zone 的 peer，
+// 其他 zone 的 peer 至少占 1 - LocalRatio，任意一方不够时由另一方补足
+func selectPeers(candidates []Peer, zone string, numWant int) []Peer {
+	if len(candidates) <= numWant {
+		return candidates
+	}
+	if zone == "" || numWant == 0 {
+		return candidates[:numWant]
+	}
+	var local, remote []Peer
+	for _, p := range candidates {
+		if p.Zone == zone {
+			local = append(local, p)
+		} else {
+			remote = append(remote, p)
+		}
+	}
+	// 向下取整，保证 numWant 较小时也有其他 zone 的 peer
+	numLocal := int(math.Max(1, math.Floor(float64(numWant)*localRatio())))
+	if numLocal > len(local) {
+		numLocal = len(local)
+	}
+	numRemote := numWant - numLocal
+	if numRemote > len(remote) {
+		numRemote = len(remote)
+		numLocal = numWant - numRemote
+	}
+	return append(local[:numLocal:numLocal], remote[:numRemote]...)
+}
@@ -0,0 +1,73 @@
+package libtracker
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZoneOf(t *testing.T) {
+	nets := func(cidrs ...string) []*net.IPNet {
+		n, err := ParseIPNets(cidrs)
+		assert.NoError(t, err)
+		return n
+	}
+	SetTopology(Topology{Zones: map[string][]*net.IPNet{
+		"dc":    nets("10.0.0.0/8"),
+		"rack1": nets("10.1.0.0/16", "fd00:1::/32"),
+	}})
+	defer SetTopology(Topology{})
+	assert.Equal(t, "rack1", zoneOf("10.1.2.3"))
+	assert.Equal(t, "dc", zoneOf("10.2.2.3"))
+	assert.Equal(t, "rack1", zoneOf("fd00:1::1"))
+	assert.Equal(t, "", zoneOf("192.168.0.1"))
+}
+
+func TestSelectPeers(t *testing.T) {
+	var candidates []Peer
+	for i := 0; i < 20; i++ {
+		zone := "local"
+		if i%2 == 0 {
+			zone = "remote"
+		}
+		candidates = append(candidates, Peer{IP: net.IPv4(10, 0, 0, byte(i)), Zone: zone})
+	}
+	count := func(peers []Peer) map[string]int {
+		m := make(map[string]int)
+		for _, p := range peers {
+			m[p.Zone]++
+		}
+		return m
+	}
+
+	assert.Equal(t, map[string]int{"local": 8, "remote": 2}, count(selectPeers(candidates, "local", 10)))
+	// 没有 zone 时不区分
+	assert.Len(t, selectPeers(candidates, "", 10), 10)
+	// 同一 zone 的 peer 不够时用其他 zone 补足
+	assert.Equal(t, map[string]int{"local": 10, "remote": 6}, count(selectPeers(candidates, "local", 16)))
+	assert.Len(t, selectPeers(candidates, "other", 10), 10)
+
+	SetTopology(Topology{LocalRatio: 1})
+	defer SetTopology(Topology{})
+	assert.Equal(t, map[string]int{"local": 10}, count(selectPeers(candidates, "local", 10)))
+}
+
+func TestGetPeersZone(t *testing.T) {
+	infoHash := strings.Repeat("e", 20)
+	for i := 0; i < 10; i++ {
+		PutPeer("zone", infoHash, "", "rack1", fmt.Sprintf("10.1.0.%d", i), 6881, true)
+		PutPeer("zone", infoHash, "", "rack2", fmt.Sprintf("10.2.0.%d", i), 6881, true)
+	}
+	peers, _, _ := GetPeers("zone", infoHash, "10.1.0.100", 6881, "rack1", false, 5)
+	assert.Len(t, peers, 5)
+	local := 0
+	for _, p := range peers {
+		if p.Zone == "rack1" {
+			local++
+		}
+	}
+	assert.Equal(t, 4, local)
+}